@@ -0,0 +1,175 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package bank
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// Policy is the access policy of the bank chaincode. Employees work on the referrals they made and
+// managers on all of them, including syncing them from the partners' copies. Partner roles see the
+// referrals forwarded to their partner, auditors read everything and analysts read the referrals and
+// their statistics
+var Policy = partnerlogic.AccessPolicy{
+	Grants: map[string]partnerlogic.Grants{
+		"init": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"updateConfig": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"onboardPartner": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"updatePartnerState": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"offboardPartner": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"createReferral": {partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny, partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"updateReferralStatus": {partnerlogic.RoleBankEmployee: partnerlogic.ScopeOwnReferral, partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"updateMortgateData": {partnerlogic.RoleBankEmployee: partnerlogic.ScopeOwnReferral, partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"syncPartnerStatus": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"read": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeOwnReferral,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RoleAnalyst: partnerlogic.ScopeAny,
+			partnerlogic.RolePartnerAgent: partnerlogic.ScopePartnerReferral,
+			partnerlogic.RolePartnerAdmin: partnerlogic.ScopePartnerReferral,
+		},
+		"searchByStatus": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny, partnerlogic.RoleAnalyst: partnerlogic.ScopeAny},
+		"searchByDepartment": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny, partnerlogic.RoleAnalyst: partnerlogic.ScopeAny},
+		"reconcileWithPartner": {
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RolePartnerAdmin: partnerlogic.ScopeOwnPartner,
+		},
+		"getConfig": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+		},
+		"readPartner": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RolePartnerAgent: partnerlogic.ScopeOwnPartner,
+			partnerlogic.RolePartnerAdmin: partnerlogic.ScopeOwnPartner,
+		},
+		"findDuplicateReferrals": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny},
+		"redactCustomer": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"revokeConsent": {partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny, partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"readAuditLog": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny},
+		"auditedRead": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeOwnReferral,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RoleAnalyst: partnerlogic.ScopeAny,
+			partnerlogic.RolePartnerAgent: partnerlogic.ScopePartnerReferral,
+			partnerlogic.RolePartnerAdmin: partnerlogic.ScopePartnerReferral,
+		},
+		"accessReport": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny},
+		"referralStats": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny, partnerlogic.RoleAnalyst: partnerlogic.ScopeAny},
+		"stageDurations": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny, partnerlogic.RoleAuditor: partnerlogic.ScopeAny, partnerlogic.RoleAnalyst: partnerlogic.ScopeAny},
+		"setGoal": {partnerlogic.RoleBankManager: partnerlogic.ScopeAny},
+		"readGoals": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RoleAnalyst: partnerlogic.ScopeAny,
+		},
+		"leaderboard": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RoleAnalyst: partnerlogic.ScopeAny,
+		},
+		"readAllPartners": {
+			partnerlogic.RoleBankEmployee: partnerlogic.ScopeAny,
+			partnerlogic.RoleBankManager: partnerlogic.ScopeAny,
+			partnerlogic.RoleAuditor: partnerlogic.ScopeAny,
+			partnerlogic.RoleAnalyst: partnerlogic.ScopeAny,
+		},
+	},
+	Scopes: map[string]partnerlogic.ScopeCheck{
+		partnerlogic.ScopeOwnReferral: ownReferral,
+		partnerlogic.ScopePartnerReferral: partnerReferral,
+		partnerlogic.ScopeOwnPartner: ownPartner,
+	},
+}
+
+// Attributes a new referral to the employee making it. An employee's referrals carry the employee id in
+// their certificate, so they cannot make referrals in another employee's name. Managers may record
+// referrals for their employees
+func attributeReferral(referral *partnerlogic.CustomerReferral, stub shim.ChaincodeStubInterface) error {
+	caller, err := partnerlogic.GetCaller(stub)
+	if err != nil {
+		return err
+	}
+
+	if caller.Role != partnerlogic.RoleBankEmployee {
+		return nil
+	}
+
+	if caller.EmployeeId == "" {
+		return partnerlogic.Forbidden("Caller " + caller.Id + " has no " + partnerlogic.AttrEmployeeId + " attribute")
+	}
+
+	if referral.EmployeeId != "" && referral.EmployeeId != caller.EmployeeId {
+		return partnerlogic.Forbidden("Employee " + caller.EmployeeId + " may not make referrals for employee " + referral.EmployeeId).WithDetail("employeeId", referral.EmployeeId)
+	}
+
+	referral.EmployeeId = caller.EmployeeId
+
+	return nil
+}
+
+// The referral named by the first argument must have been made by the calling employee
+func ownReferral(stub shim.ChaincodeStubInterface, caller partnerlogic.Caller, args []string) error {
+	if len(args) == 0 {
+		return partnerlogic.WrongArgumentCount("the referral id first")
+	}
+
+	referral, err := partnerlogic.GetCustomerReferral(args[0], stub)
+	if err != nil {
+		return err
+	}
+
+	if caller.EmployeeId == "" || referral.EmployeeId != caller.EmployeeId {
+		return partnerlogic.Forbidden("Referral " + args[0] + " was not made by employee " + caller.EmployeeId)
+	}
+
+	return nil
+}
+
+// The referral named by the first argument must have been forwarded to the caller's partner
+func partnerReferral(stub shim.ChaincodeStubInterface, caller partnerlogic.Caller, args []string) error {
+	if len(args) == 0 {
+		return partnerlogic.WrongArgumentCount("the referral id first")
+	}
+
+	referral, err := partnerlogic.GetCustomerReferral(args[0], stub)
+	if err != nil {
+		return err
+	}
+
+	if _, forwarded := referral.PartnerReferralIds[caller.PartnerName]; caller.PartnerName == "" || !forwarded {
+		return partnerlogic.Forbidden("Referral " + args[0] + " was not forwarded to partner " + caller.PartnerName)
+	}
+
+	return nil
+}
+
+// The first argument must name the caller's partner
+func ownPartner(stub shim.ChaincodeStubInterface, caller partnerlogic.Caller, args []string) error {
+	if len(args) == 0 {
+		return partnerlogic.Forbidden("Partner roles must name their partner")
+	}
+
+	return partnerlogic.RequirePartner(caller, args[0])
+}
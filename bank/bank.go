@@ -0,0 +1,464 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bank
+
+import (
+	"fmt"
+    "encoding/json"
+	"sort"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// PartnerChaincode is the bank chaincode. It stores and updates referral information on the blockchain
+// and forwards referrals to the partner chaincodes. Its configuration is kept in world state, see
+// partnerlogic.ChaincodeConfig
+type PartnerChaincode struct {
+}
+
+// The bank's own records are kept off the referral ids as well as the shared ones
+func init() {
+	partnerlogic.ReserveKeys([]string{duplicatesKey}, []string{customerHashKeyPrefix, goalKeyPrefix})
+}
+
+// Init resets all the things
+func (t *PartnerChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	return partnerlogic.Respond(t.init(stub, args))
+}
+
+func (t *PartnerChaincode) init(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	// Initialize the partner names
+	config, err := partnerlogic.ParseInitConfig(args, partnerlogic.ChaincodeConfig{})
+	if err != nil {
+		return nil, err
+	}
+	
+	fmt.Println("Initializing chaincode for partner: " + config.PartnerName)
+	return partnerlogic.SaveConfig(config, stub)
+}
+
+// Invoke is our entry point to invoke a chaincode function. Callers are authorized by Policy and query
+// functions are dispatched with a read only stub
+func (t *PartnerChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return partnerlogic.Dispatch(stub, partnerlogic.Functions{
+		"init": t.init,
+		"createReferral": t.createReferral,
+		"updateReferralStatus": t.updateReferralStatus,
+		"updateMortgateData": t.updateMortgateData,
+		"syncPartnerStatus": t.syncPartnerStatus,
+		"updateConfig": partnerlogic.UpdateConfig,
+		"onboardPartner": partnerlogic.OnboardPartner,
+		"updatePartnerState": partnerlogic.UpdatePartnerState,
+		"offboardPartner": partnerlogic.OffboardPartner,
+		"redactCustomer": t.redactCustomer,
+		"revokeConsent": t.revokeConsent,
+		"auditedRead": partnerlogic.AuditedRead,
+		"setGoal": t.setGoal,
+	}, partnerlogic.Functions{
+		"read": partnerlogic.Read,
+		"searchByStatus": partnerlogic.SearchByStatusQuery,
+		"searchByDepartment": t.findAllReferrals,
+		"reconcileWithPartner": t.reconcileWithPartner,
+		"getConfig": partnerlogic.GetConfig,
+		"readPartner": partnerlogic.ReadPartner,
+		"readAllPartners": partnerlogic.ReadAllPartners,
+		"findDuplicateReferrals": t.findDuplicateReferrals,
+		"readAuditLog": partnerlogic.ReadAuditLog,
+		"accessReport": partnerlogic.AccessReport,
+		"referralStats": partnerlogic.ReferralStatsQuery(partnerlogic.CustomerReferralStages),
+		"stageDurations": partnerlogic.StageDurationsQuery(partnerlogic.CustomerReferralStages),
+		"readGoals": t.readGoals,
+		"leaderboard": t.leaderboard,
+	}, Policy)
+}
+
+func (t *PartnerChaincode) findAllReferrals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	config, err := partnerlogic.LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	return partnerlogic.FindAllReferrals(stub, config.PartnerName)
+}
+
+// Calls a function of the partner chaincodes a referral was forwarded to with the id each partner knows
+// the referral by, and returns what each partner answered by partner name. Only the partners in scope
+// are called, or all of them when scope is nil. Partners without a chaincode and referrals a partner no
+// longer has are skipped
+func callForwardedPartners(function string, referralId string, referral partnerlogic.CustomerReferral, scope []string, stub shim.ChaincodeStubInterface) (map[string][]byte, error) {
+	responses := map[string][]byte{}
+
+	// Every endorser must call the partners in the same order
+	var partnerNames []string
+	for partnerName := range referral.PartnerReferralIds {
+		if scope == nil || contains(scope, partnerName) {
+			partnerNames = append(partnerNames, partnerName)
+		}
+	}
+	sort.Strings(partnerNames)
+
+	for _, partnerName := range partnerNames {
+		partnerReferralId := referral.PartnerReferralIds[partnerName]
+
+		partner, err := partnerlogic.GetPartner(partnerName, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if partner == nil || partner.ChaincodeName == "" {
+			continue
+		}
+
+		fmt.Println("Calling " + function + " for referral " + partnerReferralId + " on " + partner.ChaincodeName)
+		response, err := partnerlogic.CallChaincode(partner.ChaincodeName, function, []string{partnerReferralId}, stub)
+		if err != nil && partnerlogic.ToChaincodeError(err).Code != partnerlogic.CodeNotFound {
+			return nil, partnerlogic.Internal("Failed to call " + function + " for referral " + referralId + " on partner " + partnerName, err)
+		}
+
+		if err == nil {
+			responses[partnerName] = response
+		}
+	}
+
+	return responses, nil
+}
+
+func contains(values []string, value string) bool {
+	for i := range values {
+		if values[i] == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the ids and the stored referrals of every referral made for a customer
+func findCustomerReferrals(customerId string, stub shim.ChaincodeStubInterface) ([]string, []partnerlogic.CustomerReferral, error) {
+	var referralIds []string
+	var referrals []partnerlogic.CustomerReferral
+
+	for _, status := range partnerlogic.ReferralStatuses {
+		valAsbytes, err := stub.GetState(status)
+		if err != nil {
+			return nil, nil, partnerlogic.Internal("Failed to get state for " + status, err)
+		}
+
+		for _, referralId := range partnerlogic.SplitReferralIds(partnerlogic.BytesToString(valAsbytes)) {
+			referral, err := partnerlogic.GetCustomerReferral(referralId, stub)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if referral.CustomerId == customerId {
+				referralIds = append(referralIds, referralId)
+				referrals = append(referrals, referral)
+			}
+		}
+	}
+
+	return referralIds, referrals, nil
+}
+
+// updateMortgateData - invoke function to updateMortgageData on the referral key/value pair
+func (t *PartnerChaincode) updateMortgateData(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var key, value string
+	var err error
+	var referral partnerlogic.CustomerReferral
+	var mortgageData partnerlogic.Mortgage
+	var valAsbytes []byte
+	
+	fmt.Println("running updateMortgageData()")
+
+	if len(args) != 2 {
+		return nil, partnerlogic.WrongArgumentCount("2. name of the key and value to set")
+	}
+
+	key = args[0] // The referral id
+	value = args[1] // The mortgage data
+	
+	// Look up the referral that matches the current referral id
+	referral, err = partnerlogic.GetCustomerReferral(key, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.DecodeStrict(value, &mortgageData)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Save the current status so that it can be unindexed once we update the referral object
+	oldStatus := referral.Status
+	
+	// Set the referral status to the new value
+	referral.Status = "PENDING"
+	
+	referral.StatusHistory, err = partnerlogic.RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = partnerlogic.CountCustomerReferral(key, referral, oldStatus, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	referral.Mortgage = &mortgageData
+	
+	// Serialize the object to a JSON string to be stored in the ledger
+	valAsbytes, err = json.Marshal(referral)
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to marshal referral " + key, err)
+	}
+	
+	// Store the json string in the ledger
+	err = stub.PutState(key, valAsbytes) //write the variable into the chaincode state
+	
+	if err != nil {
+		return nil, err
+	}
+	
+	// Move the referral to the index of its new status
+	err = partnerlogic.ReindexByStatus(key, oldStatus, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.EmitCustomerReferralEvent(partnerlogic.EventMortgageUpdated, key, oldStatus, referral, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	return nil, nil
+}
+
+// updateReferral - invoke function to updateReferral key/value pair. The status of a referral forwarded
+// to partners is derived from theirs by syncPartnerStatus, so it cannot be set here
+func (t *PartnerChaincode) updateReferralStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var key, value string
+	var err error
+	var referral partnerlogic.CustomerReferral
+	var valAsbytes []byte
+	
+	fmt.Println("running updateReferral()")
+
+	if len(args) != 2 {
+		return nil, partnerlogic.WrongArgumentCount("2. name of the key and value to set")
+	}
+
+	key = args[0] // The referral id
+	value = args[1] // The new status
+	
+	err = partnerlogic.ValidateEnum("status", value, partnerlogic.ReferralStatuses)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Look up the referral that matches the current referral id
+	referral, err = partnerlogic.GetCustomerReferral(key, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	if len(referral.PartnerReferralIds) > 0 {
+		return nil, partnerlogic.Conflict("Referral " + key + " was forwarded to partners, its status follows theirs").WithDetail("status", referral.Status)
+	}
+	
+	// Save the current status so that it can be unindexed once we update the referral object
+	oldStatus := referral.Status;
+	
+	// Set the referral status to the new value
+	referral.Status = value;
+	
+	referral.StatusHistory, err = partnerlogic.RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = partnerlogic.CountCustomerReferral(key, referral, oldStatus, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Serialize the object to a JSON string to be stored in the ledger
+	valAsbytes, err = json.Marshal(referral)
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to marshal referral " + key, err)
+	}
+	
+	// Store the json string in the ledger
+	err = stub.PutState(key, valAsbytes) //write the variable into the chaincode state
+	
+	if err != nil {
+		return nil, err
+	}
+	
+	// Move the referral to the index of its new status
+	err = partnerlogic.ReindexByStatus(key, oldStatus, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.EmitCustomerReferralEvent(partnerlogic.EventReferralStatusUpdated, key, oldStatus, referral, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	return nil, nil
+}
+
+// createReferral - invoke function to write key/value pair
+func (t *PartnerChaincode) createReferral(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var referralKey, referralData string
+	var err error
+	fmt.Println("running createReferral()")
+
+	if len(args) != 2 {
+		return nil, partnerlogic.WrongArgumentCount("2 parameters, name of the key and value to set")
+	}
+
+	referralKey = args[0] //rename for funsies
+	referralData = args[1]
+	
+	// Deserialize the input string into a GO data structure to hold the referral
+	var referral partnerlogic.CustomerReferral
+
+	// Nothing is written or forwarded unless the referral is valid
+	err = partnerlogic.DecodeStrict(referralData, &referral)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = attributeReferral(&referral, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.ReadTransientPII(&referral, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.ValidateCustomerReferral(referralKey, referral)
+	if err != nil {
+		return nil, err
+	}
+
+	referral.Status = partnerlogic.InitialStatus
+	
+	err = partnerlogic.EnsureNewReferral(referralKey, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Only departments served by an active partner in the registry can be referred to
+	err = partnerlogic.ValidateDepartments(referral.Departments, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.ValidateConsent(referral, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	config, err := partnerlogic.LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	// A customer referred before stays attributed to their first referral
+	referral.CustomerHash, err = customerHash(referral, config, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	earlierReferrals, err := customerReferrals(referral.CustomerHash, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	if len(earlierReferrals) > 0 && config.DuplicatePolicy == partnerlogic.DuplicateReject {
+		return nil, partnerlogic.Conflict("The customer was already referred").WithDetail("firstReferralId", earlierReferrals[0])
+	}
+	
+	if len(earlierReferrals) > 0 {
+		referral.DuplicateOf = earlierReferrals[0]
+	} else {
+		// Create the referral on the partner chaincodes in the same transaction so the copies cannot
+		// disagree. Duplicates are not forwarded, the partners already have the customer
+		err = partnerlogic.ForwardReferral(referralKey, &referral, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	referral.StatusHistory, err = partnerlogic.RecordStatusChange(nil, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = partnerlogic.CountCustomerReferral(referralKey, referral, "", stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	// The partners were sent their own copy above, the bank's copy is only readable with the bank's key
+	err = partnerlogic.SealCustomerReferral(referralKey, &referral, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	valAsbytes, err := json.Marshal(referral)
+	if err != nil {
+		return nil, err
+	}
+	
+	err = stub.PutState(referralKey, valAsbytes) //write the variable into the chaincode state
+	if err != nil {
+		return nil, err
+	}
+	
+	err = partnerlogic.IndexByStatus(referralKey, referral.Status, stub)
+	
+	if err != nil {
+		return nil, err
+	}
+	
+	err = indexCustomerHash(referralKey, referral.CustomerHash, stub)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Create a ledger record that indexes the referral id by the partner
+	for i := range referral.Departments {
+	    if referral.Departments[i] == config.PartnerName {
+			err = partnerlogic.IndexByPartner(referralKey, config.PartnerName, stub)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	
+	err = partnerlogic.EmitCustomerReferralEvent(partnerlogic.EventReferralCreated, referralKey, "", referral, stub)
+	if err != nil {
+		return nil, err
+	}
+		
+	return valAsbytes, nil
+}
\ No newline at end of file
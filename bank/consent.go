@@ -0,0 +1,315 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// ConsentRevocationSummary lists the referrals a customer's consent was revoked on and the open ones
+// among them that were declined
+type ConsentRevocationSummary struct {
+	CustomerId string `json:"customerId"`
+	Scope []string `json:"scope,omitempty"`
+	ReferralIds []string `json:"referralIds"`
+	DeclinedReferralIds []string `json:"declinedReferralIds"`
+}
+
+// revokeConsent - invoke function withdrawing a customer's consent to share their referrals with the
+// partners named in the optional JSON array, or with every partner when it is omitted. Open referrals
+// to a revoked partner are declined here and on the partner chaincodes they were forwarded to
+func (t *PartnerChaincode) revokeConsent(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var summary ConsentRevocationSummary
+
+	fmt.Println("running revokeConsent()")
+
+	if len(args) != 1 && len(args) != 2 {
+		return nil, partnerlogic.WrongArgumentCount("the customer id and optionally the partners to revoke consent for")
+	}
+
+	summary.CustomerId = args[0]
+	summary.ReferralIds = []string{}
+	summary.DeclinedReferralIds = []string{}
+
+	err := partnerlogic.Required("customerId", summary.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) == 2 {
+		err = partnerlogic.DecodeStrict(args[1], &summary.Scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(summary.Scope) == 0 {
+			return nil, partnerlogic.InvalidArgument("scope", "Name at least one partner to revoke consent for")
+		}
+
+		for i := range summary.Scope {
+			err = partnerlogic.Required("scope", summary.Scope[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	referralIds, referrals, err := findCustomerReferrals(summary.CustomerId, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(referralIds) == 0 {
+		return nil, partnerlogic.NotFound("Did not find referrals for customer " + summary.CustomerId)
+	}
+
+	var revocations []consentRevocation
+	for i, referral := range referrals {
+		revoked, err := revokedPartners(referral, summary.Scope, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(revoked) == 0 {
+			continue
+		}
+
+		declined, err := declinesReferral(referral, revoked, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, consentRevocation{referralId: referralIds[i], referral: referral, revoked: revoked, declined: declined})
+	}
+
+	partnerCopies, err := revokePartnerCopies(revocations, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revocation := range revocations {
+		err = revokeReferralConsent(revocation, partnerCopies, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.ReferralIds = append(summary.ReferralIds, revocation.referralId)
+		if revocation.declined {
+			summary.DeclinedReferralIds = append(summary.DeclinedReferralIds, revocation.referralId)
+		}
+	}
+
+	err = partnerlogic.RecordAudit(partnerlogic.AuditEntry{Action: partnerlogic.AuditConsentRevoked, Subject: summary.CustomerId, ReferralIds: summary.ReferralIds}, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(summary)
+}
+
+// Returns the partners the referral is shared with, through its consent or its departments, that the
+// revocation covers
+func revokedPartners(referral partnerlogic.CustomerReferral, scope []string, stub shim.ChaincodeStubInterface) ([]string, error) {
+	var partnerNames, revoked []string
+
+	if referral.Consent != nil {
+		partnerNames = append(partnerNames, referral.Consent.Scope...)
+	}
+
+	for i := range referral.Departments {
+		partner, err := partnerlogic.FindPartnerForDepartment(referral.Departments[i], stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if partner != nil {
+			partnerNames = append(partnerNames, partner.PartnerName)
+		}
+	}
+
+	for _, partnerName := range partnerNames {
+		if (scope == nil || contains(scope, partnerName)) && !contains(revoked, partnerName) {
+			revoked = append(revoked, partnerName)
+		}
+	}
+
+	return revoked, nil
+}
+
+// A referral the revocation applies to, the partners revoked on it and whether it is declined
+type consentRevocation struct {
+	referralId string
+	referral partnerlogic.CustomerReferral
+	revoked []string
+	declined bool
+}
+
+// Returns whether the referral is still open and one of its departments is served by a revoked partner
+func declinesReferral(referral partnerlogic.CustomerReferral, revoked []string, stub shim.ChaincodeStubInterface) (bool, error) {
+	if !partnerlogic.IsOpenStatus(referral.Status) {
+		return false, nil
+	}
+
+	for i := range referral.Departments {
+		partner, err := partnerlogic.FindPartnerForDepartment(referral.Departments[i], stub)
+		if err != nil {
+			return false, err
+		}
+
+		if partner != nil && contains(revoked, partner.PartnerName) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Calls revokeConsent once on each revoked partner with all the declined referrals forwarded to it, so
+// the partner moves them between its status indexes together. Returns the partner copies by partner
+// name and partner referral id
+func revokePartnerCopies(revocations []consentRevocation, stub shim.ChaincodeStubInterface) (map[string]map[string]partnerRecord, error) {
+	partnerReferralIds := map[string][]string{}
+	for _, revocation := range revocations {
+		if !revocation.declined {
+			continue
+		}
+
+		for partnerName, partnerReferralId := range revocation.referral.PartnerReferralIds {
+			if contains(revocation.revoked, partnerName) {
+				partnerReferralIds[partnerName] = append(partnerReferralIds[partnerName], partnerReferralId)
+			}
+		}
+	}
+
+	// Every endorser must call the partners in the same order
+	var partnerNames []string
+	for partnerName := range partnerReferralIds {
+		partnerNames = append(partnerNames, partnerName)
+	}
+	sort.Strings(partnerNames)
+
+	partnerCopies := map[string]map[string]partnerRecord{}
+	for _, partnerName := range partnerNames {
+		partner, err := partnerlogic.GetPartner(partnerName, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if partner == nil || partner.ChaincodeName == "" {
+			continue
+		}
+
+		ids := partnerReferralIds[partnerName]
+		sort.Strings(ids)
+
+		fmt.Println("Calling revokeConsent for " + strings.Join(ids, ",") + " on " + partner.ChaincodeName)
+		response, err := partnerlogic.CallChaincode(partner.ChaincodeName, "revokeConsent", ids, stub)
+		if err != nil {
+			return nil, partnerlogic.Internal("Failed to call revokeConsent on partner " + partnerName, err)
+		}
+
+		// The partners decline their open copies and answer with the ones they have
+		var copies map[string]partnerRecord
+		err = json.Unmarshal(response, &copies)
+		if err != nil {
+			return nil, partnerlogic.Internal("Failed to decode the referrals revoked on partner " + partnerName, err)
+		}
+
+		partnerCopies[partnerName] = copies
+	}
+
+	return partnerCopies, nil
+}
+
+// Records the revocation on the referral and declines it, along with the bank's record of the partner
+// copies, when the revocation declines it
+func revokeReferralConsent(revocation consentRevocation, partnerCopies map[string]map[string]partnerRecord, stub shim.ChaincodeStubInterface) error {
+	referralId := revocation.referralId
+	referral := revocation.referral
+
+	if referral.Consent != nil {
+		entry, err := partnerlogic.NewConsentRevocation(revocation.revoked, stub)
+		if err != nil {
+			return err
+		}
+
+		referral.Consent.Revoke(entry)
+	}
+
+	oldStatus := referral.Status
+	if revocation.declined {
+		var partnerNames []string
+		for partnerName := range referral.PartnerReferralIds {
+			partnerNames = append(partnerNames, partnerName)
+		}
+		sort.Strings(partnerNames)
+
+		// The bank's record of the partner copies follows what the partners answered
+		for _, partnerName := range partnerNames {
+			partnerReferral, ok := partnerCopies[partnerName][referral.PartnerReferralIds[partnerName]]
+			if !ok || partnerReferral.Status == "" {
+				continue
+			}
+
+			if referral.PartnerStatuses == nil {
+				referral.PartnerStatuses = map[string]string{}
+			}
+			referral.PartnerStatuses[partnerName] = partnerReferral.Status
+
+			err := partnerlogic.EmitPartnerCopyEvent(partnerlogic.EventReferralStatusUpdated, partnerName, referral.PartnerReferralIds[partnerName], partnerReferral.StatusHistory, partnerReferral.Compensation, stub)
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		referral.Status = "DECLINED"
+		referral.StatusHistory, err = partnerlogic.RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+		if err != nil {
+			return err
+		}
+
+		err = partnerlogic.CountCustomerReferral(referralId, referral, oldStatus, stub)
+		if err != nil {
+			return err
+		}
+	}
+
+	valAsbytes, err := json.Marshal(referral)
+	if err != nil {
+		return partnerlogic.Internal("Failed to marshal referral " + referralId, err)
+	}
+
+	err = stub.PutState(referralId, valAsbytes)
+	if err != nil {
+		return partnerlogic.Internal("Failed to update state for " + referralId, err)
+	}
+
+	if !revocation.declined {
+		return nil
+	}
+
+	err = partnerlogic.ReindexByStatus(referralId, oldStatus, referral.Status, stub)
+	if err != nil {
+		return err
+	}
+
+	return partnerlogic.EmitCustomerReferralEvent(partnerlogic.EventReferralStatusUpdated, referralId, oldStatus, referral, stub)
+}
\ No newline at end of file
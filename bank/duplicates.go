@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package bank
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// The bank's gateway passes the salt customer hashes are computed with in this transient field, so the
+// salt never reaches the ledger. A caller choosing the salt could hash a customer so they are never
+// matched, so a salt whose SHA-256 is not the configured duplicateSaltHash is refused
+const DuplicateSaltTransientName = "duplicateSalt"
+
+// Each customer hash indexes the referrals of that customer, oldest first, under this prefix. The
+// hashes referred more than once are listed under duplicatesKey
+const customerHashKeyPrefix = "CUSTOMER_"
+const duplicatesKey = "DUPLICATES"
+
+// DuplicateGroup is the set of referrals made for one customer. The first referral keeps the attribution
+type DuplicateGroup struct {
+	CustomerHash string `json:"customerHash"`
+	FirstReferralId string `json:"firstReferralId"`
+	ReferralIds []string `json:"referralIds"`
+}
+
+// Computes the salted hash identifying the customer of a referral. Names are compared ignoring case,
+// punctuation and spacing, and contact numbers on their digits
+func customerHash(referral partnerlogic.CustomerReferral, config partnerlogic.ChaincodeConfig, stub shim.ChaincodeStubInterface) (string, error) {
+	if config.DuplicateSaltHash == "" {
+		return "", partnerlogic.Conflict("No duplicateSaltHash is configured for customer hashes")
+	}
+
+	salt, err := partnerlogic.TransientValue(DuplicateSaltTransientName, stub)
+	if err != nil {
+		return "", err
+	}
+
+	if len(salt) == 0 {
+		return "", partnerlogic.InvalidArgument(DuplicateSaltTransientName, "The salt for customer hashes must be passed in the " + DuplicateSaltTransientName + " transient field")
+	}
+
+	saltHash := sha256.Sum256(salt)
+	if !strings.EqualFold(hex.EncodeToString(saltHash[:]), config.DuplicateSaltHash) {
+		return "", partnerlogic.Forbidden("The " + DuplicateSaltTransientName + " passed is not the configured salt")
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(normalizeName(referral.CustomerName) + "|" + normalizeContactNumber(referral.ContactNumber)))
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func normalizeName(name string) string {
+	var words []string
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words = append(words, word)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Keeps the digits of a contact number, dropping the country code of a North American number
+func normalizeContactNumber(contactNumber string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, contactNumber)
+
+	if len(digits) == 11 && strings.HasPrefix(digits, "1") {
+		return digits[1:]
+	}
+
+	return digits
+}
+
+// Returns the referrals already made for a customer, oldest first
+func customerReferrals(hash string, stub shim.ChaincodeStubInterface) ([]string, error) {
+	valAsbytes, err := stub.GetState(customerHashKeyPrefix + hash)
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to get state for customer " + hash, err)
+	}
+
+	return partnerlogic.SplitReferralIds(partnerlogic.BytesToString(valAsbytes)), nil
+}
+
+// Adds a referral to its customer's index, listing the customer as a duplicate on their second referral
+func indexCustomerHash(referralId string, hash string, stub shim.ChaincodeStubInterface) error {
+	referralIds, err := customerReferrals(hash, stub)
+	if err != nil {
+		return err
+	}
+
+	err = stub.PutState(customerHashKeyPrefix + hash, []byte(strings.Join(append(referralIds, referralId), ",")))
+	if err != nil {
+		return partnerlogic.Internal("Failed to update state for customer " + hash, err)
+	}
+
+	if len(referralIds) != 1 {
+		return nil
+	}
+
+	valAsbytes, err := stub.GetState(duplicatesKey)
+	if err != nil {
+		return partnerlogic.Internal("Failed to get state for " + duplicatesKey, err)
+	}
+
+	err = stub.PutState(duplicatesKey, []byte(strings.Join(append(partnerlogic.SplitReferralIds(partnerlogic.BytesToString(valAsbytes)), hash), ",")))
+	if err != nil {
+		return partnerlogic.Internal("Failed to update state for " + duplicatesKey, err)
+	}
+
+	return nil
+}
+
+// findDuplicateReferrals - compliance query function listing the customers referred more than once.
+// Given a referral id it returns only the group of that referral's customer
+func (t *PartnerChaincode) findDuplicateReferrals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var hashes []string
+
+	fmt.Println("running findDuplicateReferrals()")
+
+	if len(args) > 1 {
+		return nil, partnerlogic.WrongArgumentCount("at most the referral id to find the duplicates of")
+	}
+
+	if len(args) == 1 {
+		referral, err := partnerlogic.GetCustomerReferral(args[0], stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if referral.CustomerHash == "" {
+			return nil, partnerlogic.NotFound("Referral " + args[0] + " has no customer hash")
+		}
+
+		hashes = []string{referral.CustomerHash}
+	} else {
+		valAsbytes, err := stub.GetState(duplicatesKey)
+		if err != nil {
+			return nil, partnerlogic.Internal("Failed to get state for " + duplicatesKey, err)
+		}
+
+		hashes = partnerlogic.SplitReferralIds(partnerlogic.BytesToString(valAsbytes))
+	}
+
+	groups := []DuplicateGroup{}
+	for _, hash := range hashes {
+		referralIds, err := customerReferrals(hash, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(referralIds) == 0 || (len(referralIds) < 2 && len(args) == 0) {
+			continue
+		}
+
+		groups = append(groups, DuplicateGroup{CustomerHash: hash, FirstReferralId: referralIds[0], ReferralIds: referralIds})
+	}
+
+	return json.Marshal(groups)
+}
@@ -0,0 +1,371 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// Goals are kept under this prefix followed by the quarter, the scope and the employee or branch id
+const goalKeyPrefix = "GOAL_"
+
+// The scopes a goal can be set for
+const (
+	GoalEmployee = "employee"
+	GoalBranch = "branch"
+)
+
+var quarterPattern = regexp.MustCompile(`^([0-9]{4})-Q([1-4])$`)
+
+// Goal is a quarterly target for an employee or a branch. Quarter is written as 2026-Q1
+type Goal struct {
+	Quarter string `json:"quarter"`
+	Scope string `json:"scope"`
+	TargetId string `json:"targetId"`
+	ReferralsCreated int64 `json:"referralsCreated"`
+	DealsClosed int64 `json:"dealsClosed"`
+	SetBy string `json:"setBy,omitempty"`
+	UpdatedAt int64 `json:"updatedAt,omitempty"`
+}
+
+// GoalProgress is a goal with what its employee or branch achieved so far in the quarter
+type GoalProgress struct {
+	Goal
+	Created int64 `json:"created"`
+	Closed int64 `json:"closed"`
+}
+
+// Achievement totals the referrals an employee or branch created and the deals they closed in a period,
+// and the compensation partners reported for the closed deals
+type Achievement struct {
+	Created int64 `json:"created"`
+	Closed int64 `json:"closed"`
+	Compensation int64 `json:"compensation"`
+}
+
+// LeaderboardEntry ranks an employee. Employees with equal results share a rank
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	EmployeeId string `json:"employeeId"`
+	Achievement
+}
+
+// Leaderboard ranks the employees of a period by deals closed and by compensation
+type Leaderboard struct {
+	From string `json:"from,omitempty"`
+	To string `json:"to,omitempty"`
+	ByDealsClosed []LeaderboardEntry `json:"byDealsClosed"`
+	ByCompensation []LeaderboardEntry `json:"byCompensation"`
+}
+
+// Returns the first and last second of a quarter
+func quarterRange(quarter string) (int64, int64, error) {
+	match := quarterPattern.FindStringSubmatch(quarter)
+	if match == nil {
+		return 0, 0, partnerlogic.InvalidArgument("quarter", "Invalid quarter " + quarter + ", expecting YYYY-Qn")
+	}
+
+	year, _ := strconv.Atoi(match[1])
+	q, _ := strconv.Atoi(match[2])
+
+	start := time.Date(year, time.Month(3 * (q - 1) + 1), 1, 0, 0, 0, 0, time.UTC)
+	return start.Unix(), start.AddDate(0, 3, 0).Unix() - 1, nil
+}
+
+// setGoal - manager invoke function setting the quarterly goal of an employee or branch, replacing any
+// goal already set for them that quarter
+func (t *PartnerChaincode) setGoal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var goal Goal
+
+	fmt.Println("running setGoal()")
+
+	if len(args) != 1 {
+		return nil, partnerlogic.WrongArgumentCount("1. the goal")
+	}
+
+	err := partnerlogic.DecodeStrict(args[0], &goal)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = quarterRange(goal.Quarter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, err := range []error{
+		partnerlogic.ValidateEnum("scope", goal.Scope, []string{GoalEmployee, GoalBranch}),
+		partnerlogic.Required("targetId", goal.TargetId),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if goal.ReferralsCreated < 0 || goal.DealsClosed < 0 || goal.ReferralsCreated + goal.DealsClosed == 0 {
+		return nil, partnerlogic.InvalidArgument("referralsCreated", "A goal needs a positive target for referrals created or deals closed")
+	}
+
+	caller, err := partnerlogic.GetCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	goal.SetBy = caller.Id
+
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to get the transaction timestamp", err)
+	}
+	goal.UpdatedAt = timestamp.Seconds
+
+	valAsbytes, err := json.Marshal(goal)
+	if err != nil {
+		return nil, err
+	}
+
+	key := goalKeyPrefix + goal.Quarter + "_" + goal.Scope + "_" + goal.TargetId
+	err = stub.PutState(key, valAsbytes)
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to update state for " + key, err)
+	}
+
+	return valAsbytes, nil
+}
+
+// readGoals - query function returning the goals of a quarter with their progress. An optional scope
+// limits them to employee or branch goals
+func (t *PartnerChaincode) readGoals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running readGoals()")
+
+	if len(args) != 1 && len(args) != 2 {
+		return nil, partnerlogic.WrongArgumentCount("the quarter and optionally the scope")
+	}
+
+	start, end, err := quarterRange(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := goalKeyPrefix + args[0] + "_"
+	if len(args) == 2 {
+		err = partnerlogic.ValidateEnum("scope", args[1], []string{GoalEmployee, GoalBranch})
+		if err != nil {
+			return nil, err
+		}
+		prefix += args[1] + "_"
+	}
+
+	byEmployee, byBranch, err := achievements(start, end, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := stub.GetStateByRange(prefix, prefix + "~")
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to read the goals of " + args[0], err)
+	}
+	defer iterator.Close()
+
+	goals := []GoalProgress{}
+	for iterator.HasNext() {
+		var progress GoalProgress
+
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, partnerlogic.Internal("Failed to read the goals of " + args[0], err)
+		}
+
+		err = json.Unmarshal(result.Value, &progress.Goal)
+		if err != nil {
+			return nil, partnerlogic.Internal("Failed to read goal " + result.Key, err)
+		}
+
+		achieved := byEmployee[progress.TargetId]
+		if progress.Scope == GoalBranch {
+			achieved = byBranch[progress.TargetId]
+		}
+
+		if achieved != nil {
+			progress.Created = achieved.Created
+			progress.Closed = achieved.Closed
+		}
+
+		goals = append(goals, progress)
+	}
+
+	return json.Marshal(goals)
+}
+
+// leaderboard - query function ranking employees by the deals they closed and the compensation those
+// deals earned. Optional arguments limit it to the days from and to, inclusive, as YYYY-MM-DD
+func (t *PartnerChaincode) leaderboard(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var board Leaderboard
+
+	fmt.Println("running leaderboard()")
+
+	if len(args) > 2 {
+		return nil, partnerlogic.WrongArgumentCount("at most the first and last day")
+	}
+
+	if len(args) > 0 {
+		board.From = args[0]
+	}
+	if len(args) > 1 {
+		board.To = args[1]
+	}
+
+	start, end, err := partnerlogic.ParseDayRange(board.From, board.To)
+	if err != nil {
+		return nil, err
+	}
+
+	byEmployee, _, err := achievements(start, end, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	board.ByDealsClosed = rank(byEmployee, func(a *Achievement) int64 { return a.Closed })
+	board.ByCompensation = rank(byEmployee, func(a *Achievement) int64 { return a.Compensation })
+
+	return json.Marshal(board)
+}
+
+// Ranks the employees by a result, highest first
+func rank(byEmployee map[string]*Achievement, result func(a *Achievement) int64) []LeaderboardEntry {
+	entries := []LeaderboardEntry{}
+	for employeeId, achieved := range byEmployee {
+		entries = append(entries, LeaderboardEntry{EmployeeId: employeeId, Achievement: *achieved})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := result(&entries[i].Achievement), result(&entries[j].Achievement)
+		if a != b {
+			return a > b
+		}
+		return entries[i].EmployeeId < entries[j].EmployeeId
+	})
+
+	for i := range entries {
+		entries[i].Rank = i + 1
+		if i > 0 && result(&entries[i].Achievement) == result(&entries[i - 1].Achievement) {
+			entries[i].Rank = entries[i - 1].Rank
+		}
+	}
+
+	return entries
+}
+
+// Totals the referrals created and the deals closed between start and end, in seconds with 0 leaving an
+// end open, by employee and by branch. A referral counts as created when it entered its first status, and
+// its deals are counted by closedDeals
+func achievements(start int64, end int64, stub shim.ChaincodeStubInterface) (map[string]*Achievement, map[string]*Achievement, error) {
+	byEmployee := map[string]*Achievement{}
+	byBranch := map[string]*Achievement{}
+
+	inPeriod := func(timestamp int64) bool {
+		return timestamp >= start && (end == 0 || timestamp <= end)
+	}
+
+	for _, status := range partnerlogic.ReferralStatuses {
+		valAsbytes, err := stub.GetState(status)
+		if err != nil {
+			return nil, nil, partnerlogic.Internal("Failed to get state for " + status, err)
+		}
+
+		for _, referralId := range partnerlogic.SplitReferralIds(partnerlogic.BytesToString(valAsbytes)) {
+			referral, err := partnerlogic.GetCustomerReferral(referralId, stub)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(referral.StatusHistory) == 0 {
+				continue
+			}
+
+			var achieved Achievement
+
+			if inPeriod(referral.StatusHistory[0].Timestamp) {
+				achieved.Created = 1
+			}
+
+			achieved.Closed, achieved.Compensation = closedDeals(referral, inPeriod)
+
+			if achieved.Created + achieved.Closed == 0 {
+				continue
+			}
+
+			addAchievement(byEmployee, referral.EmployeeId, achieved)
+			if referral.BranchId != "" {
+				addAchievement(byBranch, referral.BranchId, achieved)
+			}
+		}
+	}
+
+	return byEmployee, byBranch, nil
+}
+
+// Returns the number of deals a referral closed in the period and the compensation they earned. Each
+// partner the referral was forwarded to is a deal, closed in the period of its latest closing as long as
+// the partner still has it CLOSED, for the compensation that closing reported. Only partners report
+// closings: the status of a referral that was not forwarded, a flagged duplicate included, is set by
+// bank employees themselves, so it earns no deal
+func closedDeals(referral partnerlogic.CustomerReferral, inPeriod func(int64) bool) (int64, int64) {
+	var closed, compensation int64
+
+	for partnerName := range referral.PartnerReferralIds {
+		if referral.PartnerStatuses[partnerName] != "CLOSED" {
+			continue
+		}
+
+		closing := lastClosing(referral.PartnerHistory[partnerName])
+		if closing == nil || !inPeriod(closing.Timestamp) {
+			continue
+		}
+
+		closed++
+		if closing.Compensation != nil {
+			compensation += *closing.Compensation
+		}
+	}
+
+	return closed, compensation
+}
+
+func lastClosing(history []partnerlogic.StatusChange) *partnerlogic.StatusChange {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Status == "CLOSED" {
+			return &history[i]
+		}
+	}
+
+	return nil
+}
+
+func addAchievement(totals map[string]*Achievement, id string, achieved Achievement) {
+	if totals[id] == nil {
+		totals[id] = &Achievement{}
+	}
+
+	totals[id].Created += achieved.Created
+	totals[id].Closed += achieved.Closed
+	totals[id].Compensation += achieved.Compensation
+}
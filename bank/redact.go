@@ -0,0 +1,183 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// RedactionSummary lists the referrals whose personal data redactCustomer erased. Referrals redacted by an
+// earlier call are not listed again
+type RedactionSummary struct {
+	CustomerId string `json:"customerId"`
+	ReferralIds []string `json:"referralIds"`
+}
+
+// redactCustomer - invoke function erasing the personal data of a customer from all of their referrals,
+// on this chaincode and on the partner chaincodes they were forwarded to. The name and contact number are
+// replaced with partnerlogic.RedactedMarker and the referrals are removed from the customer hash index.
+// Statuses, history, mortgage data and compensation are kept
+func (t *PartnerChaincode) redactCustomer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var summary RedactionSummary
+
+	fmt.Println("running redactCustomer()")
+
+	if len(args) != 1 {
+		return nil, partnerlogic.WrongArgumentCount("1. the customer id")
+	}
+
+	summary.CustomerId = args[0]
+	summary.ReferralIds = []string{}
+
+	err := partnerlogic.Required("customerId", summary.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+
+	redaction, err := partnerlogic.NewRedaction(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	referralIds, referrals, err := findCustomerReferrals(summary.CustomerId, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(referralIds) == 0 {
+		return nil, partnerlogic.NotFound("Did not find referrals for customer " + summary.CustomerId)
+	}
+
+	// A customer's referrals usually share a customer hash, whose index is rewritten once for all of them
+	var hashes []string
+	redactedByHash := map[string][]string{}
+	for i, referral := range referrals {
+		if referral.Redaction != nil {
+			continue
+		}
+
+		if referral.CustomerHash != "" {
+			if redactedByHash[referral.CustomerHash] == nil {
+				hashes = append(hashes, referral.CustomerHash)
+			}
+			redactedByHash[referral.CustomerHash] = append(redactedByHash[referral.CustomerHash], referralIds[i])
+		}
+
+		err = redactReferral(referralIds[i], referral, redaction, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.ReferralIds = append(summary.ReferralIds, referralIds[i])
+	}
+
+	err = removeCustomerHashes(hashes, redactedByHash, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = partnerlogic.RecordAudit(partnerlogic.AuditEntry{Action: partnerlogic.AuditCustomerRedacted, Subject: summary.CustomerId, ReferralIds: summary.ReferralIds}, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(summary)
+}
+
+func redactReferral(referralId string, referral partnerlogic.CustomerReferral, redaction *partnerlogic.Redaction, stub shim.ChaincodeStubInterface) error {
+	_, err := callForwardedPartners("redactReferral", referralId, referral, nil, stub)
+	if err != nil {
+		return err
+	}
+
+	referral.CustomerName = partnerlogic.RedactedMarker
+	referral.ContactNumber = partnerlogic.RedactedMarker
+	referral.PII = nil
+	referral.CustomerHash = ""
+	referral.Redaction = redaction
+
+	valAsbytes, err := json.Marshal(referral)
+	if err != nil {
+		return partnerlogic.Internal("Failed to marshal referral " + referralId, err)
+	}
+
+	err = stub.PutState(referralId, valAsbytes)
+	if err != nil {
+		return partnerlogic.Internal("Failed to update state for " + referralId, err)
+	}
+
+	return nil
+}
+
+// Removes the redacted referrals from their customers' indexes, writing each index once, and drops the
+// customers with fewer than two referrals left from the duplicates
+func removeCustomerHashes(hashes []string, redactedByHash map[string][]string, stub shim.ChaincodeStubInterface) error {
+	var resolved []string
+
+	for _, hash := range hashes {
+		var remaining []string
+
+		referralIds, err := customerReferrals(hash, stub)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range referralIds {
+			if !contains(redactedByHash[hash], id) {
+				remaining = append(remaining, id)
+			}
+		}
+
+		if len(remaining) == 0 {
+			err = stub.DelState(customerHashKeyPrefix + hash)
+		} else {
+			err = stub.PutState(customerHashKeyPrefix + hash, []byte(strings.Join(remaining, ",")))
+		}
+		if err != nil {
+			return partnerlogic.Internal("Failed to update state for customer " + hash, err)
+		}
+
+		if len(referralIds) > 1 && len(remaining) < 2 {
+			resolved = append(resolved, hash)
+		}
+	}
+
+	if len(resolved) == 0 {
+		return nil
+	}
+
+	valAsbytes, err := stub.GetState(duplicatesKey)
+	if err != nil {
+		return partnerlogic.Internal("Failed to get state for " + duplicatesKey, err)
+	}
+
+	var duplicates []string
+	for _, duplicate := range partnerlogic.SplitReferralIds(partnerlogic.BytesToString(valAsbytes)) {
+		if !contains(resolved, duplicate) {
+			duplicates = append(duplicates, duplicate)
+		}
+	}
+
+	err = stub.PutState(duplicatesKey, []byte(strings.Join(duplicates, ",")))
+	if err != nil {
+		return partnerlogic.Internal("Failed to update state for " + duplicatesKey, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// ReferralDiscrepancy describes a referral the bank and a partner disagree on. BankStatus is the partner's
+// status as the bank last synced it
+type ReferralDiscrepancy struct {
+	ReferralId string `json:"referralId"`
+	PartnerName string `json:"partnerName"`
+	PartnerReferralId string `json:"partnerReferralId"`
+	BankStatus string `json:"bankStatus"`
+	PartnerStatus string `json:"partnerStatus"`
+}
+
+// syncPartnerStatus - invoke function that brings a bank referral up to date with the copy a partner
+// holds. It is called once the partner's transaction has committed, by a bank operator or the gateway,
+// with the partner name and the partner referral id. The partner's record is read from its chaincode
+// rather than taken from the caller, so nobody can report a status the partner never recorded. The
+// overall status is then derived from every partner's status by OverallStatus
+func (t *PartnerChaincode) syncPartnerStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var partnerName, partnerReferralId string
+	var err error
+	var referral partnerlogic.CustomerReferral
+	var valAsbytes []byte
+
+	fmt.Println("running syncPartnerStatus()")
+
+	if len(args) != 2 {
+		return nil, partnerlogic.WrongArgumentCount("2. the partner name and the partner referral id")
+	}
+
+	partnerName = args[0]
+	partnerReferralId = args[1]
+
+	partnerReferral, err := readPartnerReferral(partnerName, partnerReferralId, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if partnerReferral == nil {
+		return nil, partnerlogic.NotFound("Partner " + partnerName + " has no referral " + partnerReferralId)
+	}
+
+	key := partnerReferral.BankReferralId
+	if key == "" {
+		return nil, partnerlogic.Conflict("Referral " + partnerReferralId + " of partner " + partnerName + " was not forwarded by the bank")
+	}
+
+	referral, err = partnerlogic.GetCustomerReferral(key, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the referral the bank forwarded to the partner is synced
+	if referral.PartnerReferralIds[partnerName] != partnerReferralId {
+		return nil, partnerlogic.Conflict("Referral " + key + " was not forwarded to " + partnerName + " as " + partnerReferralId)
+	}
+
+	if referral.PartnerStatuses == nil {
+		referral.PartnerStatuses = map[string]string{}
+	}
+	referral.PartnerStatuses[partnerName] = partnerReferral.Status
+
+	if referral.PartnerHistory == nil {
+		referral.PartnerHistory = map[string][]partnerlogic.StatusChange{}
+	}
+	referral.PartnerHistory[partnerName] = partnerReferral.StatusHistory
+
+	if partnerReferral.Compensation != nil {
+		if referral.PartnerCompensation == nil {
+			referral.PartnerCompensation = map[string]int64{}
+		}
+		referral.PartnerCompensation[partnerName] = *partnerReferral.Compensation
+	} else {
+		delete(referral.PartnerCompensation, partnerName)
+	}
+
+	oldStatus := referral.Status
+
+	referral.Status = referral.OverallStatus()
+
+	if oldStatus != referral.Status {
+		referral.StatusHistory, err = partnerlogic.RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		err = partnerlogic.CountCustomerReferral(key, referral, oldStatus, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	valAsbytes, err = json.Marshal(referral)
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to marshal referral " + key, err)
+	}
+
+	err = stub.PutState(key, valAsbytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldStatus != referral.Status {
+		err = partnerlogic.ReindexByStatus(key, oldStatus, referral.Status, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		err = partnerlogic.EmitCustomerReferralEvent(partnerlogic.EventReferralStatusUpdated, key, oldStatus, referral, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return valAsbytes, nil
+}
+
+// reconcileWithPartner - query function listing forwarded referrals whose partner status at the bank
+// differs from the status on the partner chaincode, which syncPartnerStatus puts right. An optional
+// partner name limits the check to that partner
+func (t *PartnerChaincode) reconcileWithPartner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var onlyPartner string
+	var discrepancies []ReferralDiscrepancy
+
+	fmt.Println("running reconcileWithPartner()")
+
+	if len(args) > 1 {
+		return nil, partnerlogic.WrongArgumentCount("at most the partner name to reconcile")
+	}
+
+	if len(args) == 1 {
+		onlyPartner = args[0]
+	}
+
+	for _, status := range partnerlogic.ReferralStatuses {
+		var referrals []partnerlogic.CustomerReferral
+
+		valAsbytes, err := partnerlogic.SearchByStatus(status, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(valAsbytes, &referrals)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, referral := range referrals {
+			for partnerName, partnerReferralId := range referral.PartnerReferralIds {
+				if onlyPartner != "" && partnerName != onlyPartner {
+					continue
+				}
+
+				partnerStatus := ""
+
+				partnerReferral, err := readPartnerReferral(partnerName, partnerReferralId, stub)
+				if err != nil && partnerlogic.ToChaincodeError(err).Code != partnerlogic.CodeNotFound {
+					return nil, err
+				}
+				if partnerReferral != nil {
+					partnerStatus = partnerReferral.Status
+				}
+
+				if partnerStatus != referral.PartnerStatuses[partnerName] {
+					discrepancies = append(discrepancies, ReferralDiscrepancy{
+						ReferralId: referral.ReferralId,
+						PartnerName: partnerName,
+						PartnerReferralId: partnerReferralId,
+						BankStatus: referral.PartnerStatuses[partnerName],
+						PartnerStatus: partnerStatus,
+					})
+				}
+			}
+		}
+	}
+
+	if discrepancies == nil {
+		discrepancies = []ReferralDiscrepancy{}
+	}
+
+	return json.Marshal(discrepancies)
+}
+
+// partnerRecord holds the fields of a partner referral the bank keeps in step with
+type partnerRecord struct {
+	ReferralId string `json:"referralId"`
+	BankReferralId string `json:"bankReferralId"`
+	Status string `json:"status"`
+	Compensation *int64 `json:"compensation"`
+	StatusHistory []partnerlogic.StatusChange `json:"statusHistory"`
+}
+
+// Reads a referral from the partner's chaincode. A referral the partner does not have is nil, and a
+// partner without a chaincode is NOT_FOUND
+func readPartnerReferral(partnerName string, partnerReferralId string, stub shim.ChaincodeStubInterface) (*partnerRecord, error) {
+	var partnerReferral partnerRecord
+
+	partner, err := partnerlogic.GetPartner(partnerName, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if partner == nil || partner.ChaincodeName == "" {
+		return nil, partnerlogic.NotFound("Partner " + partnerName + " has no chaincode")
+	}
+
+	valAsbytes, err := partnerlogic.CallChaincode(partner.ChaincodeName, "read", []string{partnerReferralId}, stub)
+	if err != nil && partnerlogic.ToChaincodeError(err).Code == partnerlogic.CodeNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, partnerlogic.Internal("Failed to read referral " + partnerReferralId + " from partner " + partnerName, err)
+	}
+
+	err = json.Unmarshal(valAsbytes, &partnerReferral)
+	if err != nil {
+		return nil, partnerlogic.Internal("Failed to read referral " + partnerReferralId + " from partner " + partnerName, err)
+	}
+
+	return &partnerReferral, nil
+}
\ No newline at end of file
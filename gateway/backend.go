@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// Package gateway exposes the referral chaincode functions as a JSON HTTP API. The HTTP layer only
+// validates requests and maps resources onto a Backend, which calls the chaincodes either through a peer
+// or on a local ledger for development
+package gateway
+
+import (
+	"errors"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// ErrNotFound is returned by backends when the referral does not exist
+var ErrNotFound = errors.New("referral not found")
+
+// Backend carries out referral operations. Invokes return the id of the transaction that carries the
+// change, once it is visible to queries
+type Backend interface {
+	CreateReferral(referral partnerlogic.CustomerReferral) (string, error)
+	UpdateStatus(referralId string, status string) (string, error)
+	UpdateMortgage(referralId string, mortgage partnerlogic.Mortgage) (string, error)
+
+	// CloseDeal closes a partner's copy of the referral and syncs the bank referral with it
+	CloseDeal(referralId string, partnerName string, dealCriteria string) (string, error)
+
+	// SyncPartner brings the bank referral up to date with the copy a partner holds, once the partner's
+	// own transaction has committed
+	SyncPartner(referralId string, partnerName string) (string, error)
+
+	// GetReferral is the one read that returns the customer's name and contact number, and it records
+	// them in the audit log. Listed and exported referrals come without them
+	GetReferral(referralId string) (partnerlogic.CustomerReferral, error)
+	ListReferrals(status string) ([]partnerlogic.CustomerReferral, error)
+
+	// ExportReferrals passes the referrals the filter selects to emit one at a time, so they can be
+	// written out as they arrive. It stops at the first error emit returns
+	ExportReferrals(filter ExportFilter, emit func(referral partnerlogic.CustomerReferral) error) error
+
+	// StageDurations reports how long referrals spent in each status, grouped by a dimension. Given a
+	// partner name it reports the partner's own copies of the referrals, which can be grouped by branch
+	StageDurations(dimension string, partnerName string, from string, to string) (partnerlogic.DurationReport, error)
+
+	// Statement returns a partner's statement for a YYYY-MM month, and RecordStatement records or
+	// confirms its hash on the partner's chaincode
+	Statement(partnerName string, period string) (partnerlogic.Statement, error)
+	RecordStatement(partnerName string, period string) (string, error)
+}
@@ -0,0 +1,278 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// Chaincodes runs the functions of the bank and partner chaincodes for a ChaincodeBackend. Submit sends
+// transient along with the proposal, waits for the transaction to commit and returns its id and the
+// function's result. Errors the chaincodes return come back as *partnerlogic.ChaincodeError
+type Chaincodes interface {
+	Submit(chaincodeName string, function string, args []string, transient map[string][]byte) (string, []byte, error)
+	Evaluate(chaincodeName string, function string, args []string) ([]byte, error)
+}
+
+// ChaincodeBackend carries out referral operations by calling the chaincodes. BankChaincode is the bank
+// chaincode name; closing a deal goes to the chaincode the partner registry lists for the partner
+type ChaincodeBackend struct {
+	Chaincodes Chaincodes
+	BankChaincode string
+}
+
+func (b *ChaincodeBackend) invoke(chaincode string, function string, args ...string) (string, error) {
+	txId, _, err := b.Chaincodes.Submit(chaincode, function, args, nil)
+	return txId, err
+}
+
+func (b *ChaincodeBackend) query(chaincode string, function string, args ...string) ([]byte, error) {
+	return b.Chaincodes.Evaluate(chaincode, function, args)
+}
+
+// CreateReferral sends the customer's name and contact number in transient data, which unlike the
+// arguments is not recorded in the block
+func (b *ChaincodeBackend) CreateReferral(referral partnerlogic.CustomerReferral) (string, error) {
+	pii, err := json.Marshal(map[string]string{"customerName": referral.CustomerName, "contactNumber": referral.ContactNumber})
+	if err != nil {
+		return "", err
+	}
+
+	referral.CustomerName = ""
+	referral.ContactNumber = ""
+
+	valAsbytes, err := json.Marshal(referral)
+	if err != nil {
+		return "", err
+	}
+
+	txId, _, err := b.Chaincodes.Submit(b.BankChaincode, "createReferral", []string{referral.ReferralId, string(valAsbytes)}, map[string][]byte{partnerlogic.PIITransientName: pii})
+	return txId, err
+}
+
+func (b *ChaincodeBackend) UpdateStatus(referralId string, status string) (string, error) {
+	return b.invoke(b.BankChaincode, "updateReferralStatus", referralId, status)
+}
+
+func (b *ChaincodeBackend) UpdateMortgage(referralId string, mortgage partnerlogic.Mortgage) (string, error) {
+	valAsbytes, err := json.Marshal(mortgage)
+	if err != nil {
+		return "", err
+	}
+
+	return b.invoke(b.BankChaincode, "updateMortgateData", referralId, string(valAsbytes))
+}
+
+// CloseDeal closes the partner's copy of the referral and then syncs the bank referral with it. Submits
+// return once their transaction has committed, so the sync reads the closed copy
+func (b *ChaincodeBackend) CloseDeal(referralId string, partnerName string, dealCriteria string) (string, error) {
+	partnerReferralId, err := b.partnerReferralId(referralId, partnerName)
+	if err != nil {
+		return "", err
+	}
+
+	partner, err := b.readPartner(partnerName)
+	if err != nil {
+		return "", err
+	}
+
+	txId, err := b.invoke(partner.ChaincodeName, "closeReferredDeal", partnerReferralId, dealCriteria)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = b.invoke(b.BankChaincode, "syncPartnerStatus", partnerName, partnerReferralId)
+	if err != nil {
+		return "", err
+	}
+
+	return txId, nil
+}
+
+func (b *ChaincodeBackend) SyncPartner(referralId string, partnerName string) (string, error) {
+	partnerReferralId, err := b.partnerReferralId(referralId, partnerName)
+	if err != nil {
+		return "", err
+	}
+
+	return b.invoke(b.BankChaincode, "syncPartnerStatus", partnerName, partnerReferralId)
+}
+
+// partnerReferralId returns the id a partner knows a bank referral by. Only the referral's partners are
+// looked at, so the read is not audited
+func (b *ChaincodeBackend) partnerReferralId(referralId string, partnerName string) (string, error) {
+	var referral partnerlogic.CustomerReferral
+
+	valAsbytes, err := b.query(b.BankChaincode, "read", referralId)
+	if chaincodeErr, ok := err.(*partnerlogic.ChaincodeError); ok && chaincodeErr.Code == partnerlogic.CodeNotFound {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	err = json.Unmarshal(valAsbytes, &referral)
+	if err != nil {
+		return "", err
+	}
+
+	partnerReferralId, forwarded := referral.PartnerReferralIds[partnerName]
+	if !forwarded {
+		return "", partnerlogic.Conflict("Referral " + referralId + " was not forwarded to " + partnerName)
+	}
+
+	return partnerReferralId, nil
+}
+
+// readPartner looks a partner up in the bank's registry
+func (b *ChaincodeBackend) readPartner(partnerName string) (partnerlogic.Partner, error) {
+	var partner partnerlogic.Partner
+
+	valAsbytes, err := b.query(b.BankChaincode, "readPartner", partnerName)
+	if err != nil {
+		return partner, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &partner)
+	return partner, err
+}
+
+// GetReferral returns the referral through auditedRead, so the customer's details the gateway hands out
+// are recorded in the bank's audit log
+func (b *ChaincodeBackend) GetReferral(referralId string) (partnerlogic.CustomerReferral, error) {
+	var referral partnerlogic.CustomerReferral
+
+	_, valAsbytes, err := b.Chaincodes.Submit(b.BankChaincode, "auditedRead", []string{referralId}, nil)
+	if chaincodeErr, ok := err.(*partnerlogic.ChaincodeError); ok && chaincodeErr.Code == partnerlogic.CodeNotFound {
+		return referral, ErrNotFound
+	} else if err != nil {
+		return referral, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &referral)
+	return referral, err
+}
+
+func (b *ChaincodeBackend) ListReferrals(status string) ([]partnerlogic.CustomerReferral, error) {
+	statuses := partnerlogic.ReferralStatuses
+	if status != "" {
+		statuses = []string{status}
+	}
+
+	referrals := []partnerlogic.CustomerReferral{}
+	for _, status := range statuses {
+		var found []partnerlogic.CustomerReferral
+
+		valAsbytes, err := b.query(b.BankChaincode, "searchByStatus", status)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(valAsbytes, &found)
+		if err != nil {
+			return nil, err
+		}
+
+		referrals = append(referrals, found...)
+	}
+
+	return referrals, nil
+}
+
+// ExportReferrals reads the referrals a status at a time, so only one status is held in memory
+func (b *ChaincodeBackend) ExportReferrals(filter ExportFilter, emit func(referral partnerlogic.CustomerReferral) error) error {
+	matches, err := filter.matcher()
+	if err != nil {
+		return err
+	}
+
+	statuses := partnerlogic.ReferralStatuses
+	if filter.Status != "" {
+		statuses = []string{filter.Status}
+	}
+
+	for _, status := range statuses {
+		var found []partnerlogic.CustomerReferral
+
+		valAsbytes, err := b.query(b.BankChaincode, "searchByStatus", status)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(valAsbytes, &found)
+		if err != nil {
+			return err
+		}
+
+		for _, referral := range found {
+			if matches(referral) {
+				err = emit(referral)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *ChaincodeBackend) StageDurations(dimension string, partnerName string, from string, to string) (partnerlogic.DurationReport, error) {
+	var report partnerlogic.DurationReport
+
+	chaincode := b.BankChaincode
+	if partnerName != "" {
+		partner, err := b.readPartner(partnerName)
+		if err != nil {
+			return report, err
+		}
+		chaincode = partner.ChaincodeName
+	}
+
+	valAsbytes, err := b.query(chaincode, "stageDurations", dimension, from, to)
+	if err != nil {
+		return report, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &report)
+	return report, err
+}
+
+
+func (b *ChaincodeBackend) Statement(partnerName string, period string) (partnerlogic.Statement, error) {
+	var statement partnerlogic.Statement
+
+	partner, err := b.readPartner(partnerName)
+	if err != nil {
+		return statement, err
+	}
+
+	valAsbytes, err := b.query(partner.ChaincodeName, "readStatement", period)
+	if err != nil {
+		return statement, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &statement)
+	return statement, err
+}
+
+func (b *ChaincodeBackend) RecordStatement(partnerName string, period string) (string, error) {
+	partner, err := b.readPartner(partnerName)
+	if err != nil {
+		return "", err
+	}
+
+	return b.invoke(partner.ChaincodeName, "recordStatement", period)
+}
\ No newline at end of file
@@ -0,0 +1,232 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// Client is a Backend that calls a gateway Server over HTTP
+type Client struct {
+	BaseURL string
+	Client *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// do sends the request body as JSON and decodes the answer into result, turning error answers back into
+// the errors the server was given
+func (c *Client) do(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+
+	if body != nil {
+		valAsbytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(valAsbytes)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL + path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return responseError(method, path, resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// responseError turns an error answer back into the error the server was given
+func responseError(method string, path string, resp *http.Response) error {
+	var errorResponse ErrorResponse
+
+	if json.NewDecoder(resp.Body).Decode(&errorResponse) != nil {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest:
+		return &ValidationError{Field: errorResponse.Field, Message: errorResponse.Error}
+	}
+
+	if errorResponse.Code != "" {
+		return &partnerlogic.ChaincodeError{Code: errorResponse.Code, Message: errorResponse.Error, Field: errorResponse.Field}
+	}
+
+	return errors.New(errorResponse.Error)
+}
+
+func (c *Client) invoke(method string, path string, body interface{}) (string, error) {
+	var response TxResponse
+
+	err := c.do(method, path, body, &response)
+	return response.TxId, err
+}
+
+func referralPath(referralId string, resource string) string {
+	return "/referrals/" + url.PathEscape(referralId) + resource
+}
+
+func (c *Client) CreateReferral(referral partnerlogic.CustomerReferral) (string, error) {
+	return c.invoke(http.MethodPost, "/referrals", CreateReferralRequest{
+		ReferralId: referral.ReferralId,
+		CustomerName: referral.CustomerName,
+		ContactNumber: referral.ContactNumber,
+		CustomerId: referral.CustomerId,
+		EmployeeId: referral.EmployeeId,
+		BranchId: referral.BranchId,
+		Departments: referral.Departments,
+		CreateDate: referral.CreateDate,
+		Status: referral.Status,
+		Mortgage: referral.Mortgage,
+		Consent: referral.Consent,
+	})
+}
+
+func (c *Client) UpdateStatus(referralId string, status string) (string, error) {
+	return c.invoke(http.MethodPatch, referralPath(referralId, "/status"), UpdateStatusRequest{Status: status})
+}
+
+func (c *Client) UpdateMortgage(referralId string, mortgage partnerlogic.Mortgage) (string, error) {
+	return c.invoke(http.MethodPatch, referralPath(referralId, "/mortgage"), mortgage)
+}
+
+func (c *Client) CloseDeal(referralId string, partnerName string, dealCriteria string) (string, error) {
+	return c.invoke(http.MethodPost, referralPath(referralId, "/close"), CloseDealRequest{Partner: partnerName, DealCriteria: dealCriteria})
+}
+
+func (c *Client) SyncPartner(referralId string, partnerName string) (string, error) {
+	return c.invoke(http.MethodPost, referralPath(referralId, "/sync"), SyncPartnerRequest{Partner: partnerName})
+}
+
+func (c *Client) GetReferral(referralId string) (partnerlogic.CustomerReferral, error) {
+	var referral partnerlogic.CustomerReferral
+
+	err := c.do(http.MethodGet, referralPath(referralId, ""), nil, &referral)
+	return referral, err
+}
+
+func (c *Client) ListReferrals(status string) ([]partnerlogic.CustomerReferral, error) {
+	var referrals []partnerlogic.CustomerReferral
+
+	path := "/referrals"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(status)
+	}
+
+	err := c.do(http.MethodGet, path, nil, &referrals)
+	return referrals, err
+}
+
+// ExportReferrals asks the gateway for JSON Lines and passes each referral on as soon as it is decoded.
+// An export the gateway could not finish ends the stream early, which is reported as an error
+func (c *Client) ExportReferrals(filter ExportFilter, emit func(referral partnerlogic.CustomerReferral) error) error {
+	query := url.Values{}
+	query.Set("format", ExportJSONLines)
+	for name, value := range map[string]string{"status": filter.Status, "from": filter.From, "to": filter.To} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	path := "/export/referrals?" + query.Encode()
+
+	resp, err := c.Client.Get(c.BaseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return responseError(http.MethodGet, path, resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var referral partnerlogic.CustomerReferral
+
+		err = decoder.Decode(&referral)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = emit(referral)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func statementPath(partnerName string, period string) string {
+	return "/statements/" + url.PathEscape(partnerName) + "/" + url.PathEscape(period)
+}
+
+func (c *Client) Statement(partnerName string, period string) (partnerlogic.Statement, error) {
+	var statement partnerlogic.Statement
+
+	err := c.do(http.MethodGet, statementPath(partnerName, period), nil, &statement)
+	return statement, err
+}
+
+func (c *Client) RecordStatement(partnerName string, period string) (string, error) {
+	var response TxResponse
+
+	err := c.do(http.MethodPost, statementPath(partnerName, period), nil, &response)
+	return response.TxId, err
+}
+
+func (c *Client) StageDurations(dimension string, partnerName string, from string, to string) (partnerlogic.DurationReport, error) {
+	var report partnerlogic.DurationReport
+
+	query := url.Values{}
+	query.Set("by", dimension)
+	for name, value := range map[string]string{"partner": partnerName, "from": from, "to": to} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	err := c.do(http.MethodGet, "/stats/durations?" + query.Encode(), nil, &report)
+	return report, err
+}
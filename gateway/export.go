@@ -0,0 +1,187 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// The formats referrals can be exported in
+const (
+	ExportCSV = "csv"
+	ExportJSONLines = "jsonl"
+)
+
+var ExportFormats = []string{ExportCSV, ExportJSONLines}
+
+var exportContentTypes = map[string]string{
+	ExportCSV: "text/csv",
+	ExportJSONLines: "application/x-ndjson",
+}
+
+func validExportFormat(format string) error {
+	if exportContentTypes[format] == "" {
+		return &ValidationError{Field: "format", Message: "must be one of " + strings.Join(ExportFormats, ", ")}
+	}
+
+	return nil
+}
+
+// ExportFilter selects the referrals to export. An empty Status selects every status, and From and To
+// are the first and last day the referrals were created, as YYYY-MM-DD, either of which can be left open
+type ExportFilter struct {
+	Status string
+	From string
+	To string
+}
+
+// Validate checks the filter's status and days
+func (f ExportFilter) Validate() error {
+	_, err := f.matcher()
+	return err
+}
+
+// matcher returns a function reporting whether a referral passes the filter
+func (f ExportFilter) matcher() (func(referral partnerlogic.CustomerReferral) bool, error) {
+	if f.Status != "" {
+		err := validStatus("status", f.Status)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	start, end, err := partnerlogic.ParseDayRange(f.From, f.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(referral partnerlogic.CustomerReferral) bool {
+		return (f.Status == "" || referral.Status == f.Status) && referral.CreateDate >= start && (end == 0 || referral.CreateDate <= end)
+	}, nil
+}
+
+// ExportWriter writes referrals out one at a time. Flush must be called once the last one is written
+type ExportWriter interface {
+	Write(referral partnerlogic.CustomerReferral) error
+	Flush() error
+}
+
+// NewExportWriter returns a writer for the format. CSV starts with a header row and flattens the
+// mortgage and per partner fields into columns, JSON Lines writes each referral as a JSON object on a
+// line of its own
+func NewExportWriter(w io.Writer, format string) (ExportWriter, error) {
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		return &csvExport{cw}, cw.Write(exportColumns)
+	case ExportJSONLines:
+		return &jsonLinesExport{json.NewEncoder(w)}, nil
+	}
+
+	return nil, validExportFormat(format)
+}
+
+var exportColumns = []string{
+	"referralId",
+	"status",
+	"customerId",
+	"employeeId",
+	"branchId",
+	"departments",
+	"createDate",
+	"duplicateOf",
+	"mortgage.mortgageNumber",
+	"mortgage.mortgageType",
+	"mortgage.rate",
+	"mortgage.amount",
+	"partnerReferralIds",
+	"partnerStatuses",
+	"partnerCompensation",
+}
+
+type csvExport struct {
+	cw *csv.Writer
+}
+
+func (e *csvExport) Write(referral partnerlogic.CustomerReferral) error {
+	var mortgage partnerlogic.Mortgage
+	if referral.Mortgage != nil {
+		mortgage = *referral.Mortgage
+	}
+
+	compensation := map[string]string{}
+	for partnerName, amount := range referral.PartnerCompensation {
+		compensation[partnerName] = strconv.FormatInt(amount, 10)
+	}
+
+	createDate := ""
+	if referral.CreateDate != 0 {
+		createDate = time.Unix(referral.CreateDate, 0).UTC().Format(time.RFC3339)
+	}
+
+	return e.cw.Write([]string{
+		referral.ReferralId,
+		referral.Status,
+		referral.CustomerId,
+		referral.EmployeeId,
+		referral.BranchId,
+		strings.Join(referral.Departments, ";"),
+		createDate,
+		referral.DuplicateOf,
+		mortgage.MortgageNumber,
+		mortgage.MortgageType,
+		mortgage.Rate,
+		mortgage.Amount,
+		joinPartners(referral.PartnerReferralIds),
+		joinPartners(referral.PartnerStatuses),
+		joinPartners(compensation),
+	})
+}
+
+func (e *csvExport) Flush() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// joinPartners flattens per partner values into Partner=value pairs separated by semicolons
+func joinPartners(values map[string]string) string {
+	var pairs []string
+	for partnerName, value := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", partnerName, value))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ";")
+}
+
+type jsonLinesExport struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonLinesExport) Write(referral partnerlogic.CustomerReferral) error {
+	return e.encoder.Encode(referral)
+}
+
+func (e *jsonLinesExport) Flush() error {
+	return nil
+}
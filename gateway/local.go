@@ -0,0 +1,262 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/joerust/referral-partners/bank"
+	"github.com/joerust/referral-partners/partnerlogic"
+	"github.com/joerust/referral-partners/simulator"
+)
+
+// LocalBankChaincode is the name the bank chaincode runs under on a local ledger. The partner chaincodes
+// run under their partner names in lower case
+const LocalBankChaincode = "bank"
+
+// The partners onboarded on a local ledger, each for the department named after it
+var localAdapters = []partnerlogic.PartnerAdapter{
+	partnerlogic.VantivAdapter{},
+	partnerlogic.PaycorAdapter{},
+	partnerlogic.MortgageAdapter{},
+}
+
+// localChaincodes runs the bank and partner chaincodes on a simulated ledger. Functions of the bank
+// chaincode are called as a bank manager and those of a partner chaincode as the partner's admin. The
+// chaincodes are development chaincodes, which keep their PII keys in their configuration, and every
+// call carries the salt for customer hashes, as the bank's gateway does. A ledger kept in a file is
+// written back to it after every transaction that commits
+type localChaincodes struct {
+	mu sync.Mutex
+	ledger *simulator.Ledger
+	callers map[string]*simulator.Identity
+	path string
+	salt string
+}
+
+// localLedgerFile is what a file kept ledger holds: the ledger and the salt, which is not on the ledger
+type localLedgerFile struct {
+	simulator.Snapshot
+	DuplicateSalt string `json:"duplicateSalt"`
+}
+
+// NewLocalBackend returns a backend running the chaincodes on an in-memory ledger, for local development
+func NewLocalBackend() (*ChaincodeBackend, error) {
+	return OpenLocalBackend("")
+}
+
+// OpenLocalBackend returns a backend running the chaincodes on a ledger kept in a file, which need not
+// exist yet, so command line tools can share a local ledger between runs. A new ledger gets its
+// chaincodes initialized with fresh PII keys and its partners onboarded
+func OpenLocalBackend(path string) (*ChaincodeBackend, error) {
+	local := &localChaincodes{ledger: simulator.NewLedger(), callers: map[string]*simulator.Identity{}, path: path}
+
+	err := local.register(LocalBankChaincode, "BankMSP", new(bank.PartnerChaincode), map[string]string{partnerlogic.AttrRole: partnerlogic.RoleBankManager})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, adapter := range localAdapters {
+		attributes := map[string]string{partnerlogic.AttrRole: partnerlogic.RolePartnerAdmin, partnerlogic.AttrPartner: adapter.PartnerName()}
+
+		err = local.register(strings.ToLower(adapter.PartnerName()), adapter.PartnerName() + "MSP", partnerlogic.NewPartnerChaincode(adapter), attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backend := &ChaincodeBackend{Chaincodes: local, BankChaincode: LocalBankChaincode}
+
+	var valAsbytes []byte
+	if path != "" {
+		valAsbytes, err = os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if valAsbytes == nil {
+		return backend, local.setUp()
+	}
+
+	var file localLedgerFile
+	err = json.Unmarshal(valAsbytes, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	local.ledger.Restore(file.Snapshot)
+	local.salt = file.DuplicateSalt
+	return backend, nil
+}
+
+// register installs a chaincode along with the identity its functions are called as, from the MSP of the
+// organization running it
+func (c *localChaincodes) register(chaincodeName string, mspId string, chaincode shim.Chaincode, attributes map[string]string) error {
+	caller, err := simulator.NewIdentity(mspId, chaincodeName, attributes)
+	if err != nil {
+		return err
+	}
+
+	c.ledger.Register(chaincodeName, chaincode)
+	c.callers[chaincodeName] = caller
+	return nil
+}
+
+// setUp initializes the chaincodes of a new ledger and onboards the partners
+func (c *localChaincodes) setUp() error {
+	c.salt = newTxId()
+	saltHash := sha256.Sum256([]byte(c.salt))
+
+	bankConfig := partnerlogic.ChaincodeConfig{PartnerName: "Bank", PIIKey: newPIIKey(), Development: true, DuplicateSaltHash: hex.EncodeToString(saltHash[:])}
+
+	err := c.initialize(LocalBankChaincode, bankConfig)
+	if err != nil {
+		return err
+	}
+
+	for _, adapter := range localAdapters {
+		chaincodeName := strings.ToLower(adapter.PartnerName())
+
+		err = c.initialize(chaincodeName, partnerlogic.ChaincodeConfig{PartnerName: adapter.PartnerName(), BankChaincodeName: LocalBankChaincode, PIIKey: newPIIKey(), Development: true})
+		if err != nil {
+			return err
+		}
+
+		partner, err := json.Marshal(partnerlogic.Partner{PartnerName: adapter.PartnerName(), ChaincodeName: chaincodeName, Departments: []string{adapter.PartnerName()}})
+		if err != nil {
+			return err
+		}
+
+		_, _, err = c.Submit(LocalBankChaincode, "onboardPartner", []string{string(partner)}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *localChaincodes) initialize(chaincodeName string, config partnerlogic.ChaincodeConfig) error {
+	valAsbytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	response := c.run(chaincodeName, nil, func() pb.Response {
+		return c.ledger.Init(chaincodeName, "init", string(valAsbytes))
+	})
+
+	return simulatorError(response)
+}
+
+// run calls a chaincode as the identity registered for it, with the transient data given and the salt.
+// The chaincodes log to standard output, which on a network is the peer's log; locally the log goes to
+// standard error instead, so it does not mix with the output of a command line tool
+func (c *localChaincodes) run(chaincodeName string, transient map[string][]byte, call func() pb.Response) pb.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	withSalt := map[string][]byte{bank.DuplicateSaltTransientName: []byte(c.salt)}
+	for name, value := range transient {
+		withSalt[name] = value
+	}
+
+	c.ledger.SetCaller(c.callers[chaincodeName])
+	c.ledger.SetTransient(withSalt)
+	return call()
+}
+
+func (c *localChaincodes) Submit(chaincodeName string, function string, args []string, transient map[string][]byte) (string, []byte, error) {
+	var txId string
+	var err error
+
+	response := c.run(chaincodeName, transient, func() pb.Response {
+		response := c.ledger.Invoke(chaincodeName, append([]string{function}, args...)...)
+		txId = c.ledger.LastTxId()
+		if response.Status < shim.ERRORTHRESHOLD {
+			err = c.save()
+		}
+		return response
+	})
+
+	if chaincodeErr := simulatorError(response); chaincodeErr != nil {
+		return "", nil, chaincodeErr
+	}
+
+	return txId, response.Payload, err
+}
+
+func (c *localChaincodes) Evaluate(chaincodeName string, function string, args []string) ([]byte, error) {
+	response := c.run(chaincodeName, nil, func() pb.Response {
+		return c.ledger.Query(chaincodeName, append([]string{function}, args...)...)
+	})
+
+	return response.Payload, simulatorError(response)
+}
+
+// save writes the ledger back to its file, if it has one. It is called from run, holding the lock
+func (c *localChaincodes) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	valAsbytes, err := json.Marshal(localLedgerFile{Snapshot: c.ledger.Snapshot(), DuplicateSalt: c.salt})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, valAsbytes, 0600)
+}
+
+// simulatorError returns the chaincode's error from a failed response
+func simulatorError(response pb.Response) error {
+	if response.Status < shim.ERRORTHRESHOLD {
+		return nil
+	}
+
+	if chaincodeErr, ok := partnerlogic.ParseError(response.Message); ok {
+		return chaincodeErr
+	}
+
+	return errors.New(response.Message)
+}
+
+func newPIIKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newTxId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway_test
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"github.com/joerust/referral-partners/gateway"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+func TestReferralFlowOnALocalLedger(t *testing.T) {
+	backend, err := gateway.NewLocalBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(gateway.NewServer(backend))
+	defer server.Close()
+	client := gateway.NewClient(server.URL)
+
+	_, err = client.CreateReferral(partnerlogic.CustomerReferral{
+		ReferralId: "r1",
+		CustomerName: "Ann Lee",
+		ContactNumber: "+1 555 123 4567",
+		CustomerId: "c1",
+		EmployeeId: "e1",
+		Departments: []string{"Vantiv"},
+		Consent: &partnerlogic.Consent{Scope: []string{"Vantiv"}, Channel: "BRANCH", Timestamp: 1, CapturedBy: "e1"},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	referral, err := client.GetReferral("r1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if referral.PartnerReferralIds["Vantiv"] == "" || referral.CustomerName != "Ann Lee" {
+		t.Fatalf("expected r1 forwarded to Vantiv with the customer's name, got %+v", referral)
+	}
+
+	_, err = client.CloseDeal("r1", "Paycor", "MID")
+	if err == nil {
+		t.Fatalf("expected closing a deal with a partner the referral was not forwarded to to fail")
+	}
+
+	_, err = client.CloseDeal("r1", "Vantiv", "MID")
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// Closing the deal syncs the bank referral with the partner's copy
+	referral, err = client.GetReferral("r1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if referral.Status != "CLOSED" || referral.PartnerStatuses["Vantiv"] != "CLOSED" || referral.PartnerCompensation["Vantiv"] != 1750 {
+		t.Fatalf("expected r1 closed by Vantiv with a compensation of 1750, got %+v", referral)
+	}
+
+	// The status of a forwarded referral follows its partners'
+	_, err = client.UpdateStatus("r1", "ACTIVE")
+	if err == nil {
+		t.Fatalf("expected setting the status of a forwarded referral to fail")
+	}
+
+	_, err = client.GetReferral("r2")
+	if err != gateway.ErrNotFound {
+		t.Fatalf("expected a missing referral to be reported as not found, got %v", err)
+	}
+}
+
+
+func TestExportingReferralsFiltersThemAndLeavesOutPII(t *testing.T) {
+	backend, err := gateway.NewLocalBackend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(gateway.NewServer(backend))
+	defer server.Close()
+	client := gateway.NewClient(server.URL)
+
+	for _, referral := range []partnerlogic.CustomerReferral{
+		{ReferralId: "r1", ContactNumber: "+1 555 123 4567", CustomerId: "c1", CreateDate: 1767225600},
+		{ReferralId: "r2", ContactNumber: "+1 555 765 4321", CustomerId: "c2", CreateDate: 1769904000},
+	} {
+		referral.CustomerName = "Ann Lee"
+		referral.EmployeeId = "e1"
+		referral.Departments = []string{"Vantiv"}
+		referral.Consent = &partnerlogic.Consent{Scope: []string{"Vantiv"}, Channel: "BRANCH", Timestamp: 1, CapturedBy: "e1"}
+
+		_, err = client.CreateReferral(referral)
+		if err != nil {
+			t.Fatalf("create %s: %v", referral.ReferralId, err)
+		}
+	}
+
+	_, err = client.CloseDeal("r1", "Vantiv", "MID")
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	resp, err := http.Get(server.URL + "/export/referrals?format=csv&status=CLOSED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[1][0] != "r1" || rows[1][1] != "CLOSED" {
+		t.Fatalf("expected a header and the closed r1, got %v", rows)
+	}
+
+	for _, row := range rows {
+		for _, field := range row {
+			if strings.Contains(field, "Ann Lee") || strings.Contains(field, "555") {
+				t.Fatalf("expected no customer name or contact number in the export, got %v", row)
+			}
+		}
+	}
+
+	// Referrals made from February on, as JSON Lines
+	var exported []partnerlogic.CustomerReferral
+	err = client.ExportReferrals(gateway.ExportFilter{From: "2026-02-01"}, func(referral partnerlogic.CustomerReferral) error {
+		exported = append(exported, referral)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	if len(exported) != 1 || exported[0].ReferralId != "r2" || exported[0].CustomerName != "" || exported[0].ContactNumber != "" {
+		t.Fatalf("expected only r2 and without its PII, got %+v", exported)
+	}
+
+	err = client.ExportReferrals(gateway.ExportFilter{Status: "LOST"}, func(referral partnerlogic.CustomerReferral) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected exporting an unknown status to fail")
+	}
+}
\ No newline at end of file
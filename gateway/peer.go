@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"strings"
+	"github.com/joerust/referral-partners/partnerlogic"
+	"github.com/joerust/referral-partners/peerclient"
+)
+
+// peerChaincodes calls the chaincodes through a peer's Fabric Gateway service. transient is sent with
+// every proposal and holds the PII keys, as piiKey.<scope>, that the chaincodes seal and reveal customer
+// details with; transient data is never written to the ledger
+type peerChaincodes struct {
+	client *peerclient.Client
+	transient map[string][]byte
+}
+
+// NewPeerBackend returns a backend calling the chaincodes through the client's peer
+func NewPeerBackend(client *peerclient.Client, bankChaincode string, transient map[string][]byte) *ChaincodeBackend {
+	return &ChaincodeBackend{
+		Chaincodes: peerChaincodes{client: client, transient: transient},
+		BankChaincode: bankChaincode,
+	}
+}
+
+func (p peerChaincodes) Submit(chaincodeName string, function string, args []string, transient map[string][]byte) (string, []byte, error) {
+	txId, result, err := p.client.Submit(chaincodeName, function, args, mergeTransient(p.transient, transient))
+	return txId, result, chaincodeError(err)
+}
+
+func (p peerChaincodes) Evaluate(chaincodeName string, function string, args []string) ([]byte, error) {
+	result, err := p.client.Evaluate(chaincodeName, function, args, p.transient)
+	return result, chaincodeError(err)
+}
+
+func mergeTransient(keys map[string][]byte, transient map[string][]byte) map[string][]byte {
+	merged := map[string][]byte{}
+	for name, value := range keys {
+		merged[name] = value
+	}
+	for name, value := range transient {
+		merged[name] = value
+	}
+
+	return merged
+}
+
+// chaincodeError returns the chaincode's own error when the gateway service passes one on, so callers
+// can tell a missing referral or a refused transition from a failed call
+func chaincodeError(err error) error {
+	gatewayErr, ok := err.(*peerclient.Error)
+	if !ok {
+		return err
+	}
+
+	// The chaincode's own error is at the end of a peer's message
+	for _, message := range append([]string{gatewayErr.Message}, gatewayErr.Details...) {
+		if start := strings.Index(message, "{"); start >= 0 {
+			if chaincodeErr, ok := partnerlogic.ParseError(message[start:]); ok {
+				return chaincodeErr
+			}
+		}
+	}
+
+	return err
+}
\ No newline at end of file
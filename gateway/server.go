@@ -0,0 +1,397 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// Server maps the referral resources onto a Backend:
+//
+//	POST  /referrals                 createReferral
+//	GET   /referrals?status=ACTIVE   searchByStatus, or every referral without a status
+//	GET   /referrals/{id}            auditedRead
+//	PATCH /referrals/{id}/status     updateReferralStatus
+//	PATCH /referrals/{id}/mortgage   updateMortgateData
+//	POST  /referrals/{id}/close      closeReferredDeal on the partner's chaincode, then syncPartnerStatus
+//	POST  /referrals/{id}/sync       syncPartnerStatus with the partner's copy of the referral
+//	GET   /stats/durations?by=partner&partner=&from=&to=
+//	                                 stageDurations, on the partner's chaincode when one is named
+//	GET   /export/referrals?format=csv&status=&from=&to=
+//	                                 the referrals as CSV or JSON Lines, written as they are read
+//	GET   /statements/{partner}/{YYYY-MM}?format=json
+//	                                 readStatement on the partner's chaincode, as JSON or CSV
+//	POST  /statements/{partner}/{YYYY-MM}
+//	                                 recordStatement on the partner's chaincode
+type Server struct {
+	Backend Backend
+}
+
+func NewServer(backend Backend) *Server {
+	return &Server{Backend: backend}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(path) == 2 && path[0] == "stats" && path[1] == "durations" && r.Method == http.MethodGet {
+		s.stageDurations(w, r)
+		return
+	}
+
+	if len(path) == 2 && path[0] == "export" && path[1] == "referrals" && r.Method == http.MethodGet {
+		s.exportReferrals(w, r)
+		return
+	}
+
+	if len(path) == 3 && path[0] == "statements" && r.Method == http.MethodGet {
+		s.statement(w, r, path[1], path[2])
+		return
+	} else if len(path) == 3 && path[0] == "statements" && r.Method == http.MethodPost {
+		s.recordStatement(w, r, path[1], path[2])
+		return
+	}
+
+	if path[0] != "referrals" {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown resource " + r.URL.Path})
+		return
+	}
+
+	var handler func(w http.ResponseWriter, r *http.Request, referralId string)
+
+	switch {
+	case len(path) == 1 && r.Method == http.MethodPost:
+		handler = s.createReferral
+	case len(path) == 1 && r.Method == http.MethodGet:
+		handler = s.listReferrals
+	case len(path) == 2 && r.Method == http.MethodGet:
+		handler = s.getReferral
+	case len(path) == 3 && path[2] == "status" && r.Method == http.MethodPatch:
+		handler = s.updateStatus
+	case len(path) == 3 && path[2] == "mortgage" && r.Method == http.MethodPatch:
+		handler = s.updateMortgage
+	case len(path) == 3 && path[2] == "close" && r.Method == http.MethodPost:
+		handler = s.closeDeal
+	case len(path) == 3 && path[2] == "sync" && r.Method == http.MethodPost:
+		handler = s.syncPartner
+	default:
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "no route for " + r.Method + " " + r.URL.Path})
+		return
+	}
+
+	referralId := ""
+	if len(path) > 1 {
+		referralId = path[1]
+	}
+
+	handler(w, r, referralId)
+}
+
+// TxResponse is the answer to every request that invokes the chaincode
+type TxResponse struct {
+	ReferralId string `json:"referralId,omitempty"`
+	TxId string `json:"txId"`
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code string `json:"code,omitempty"`
+	Field string `json:"field,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var validationErr *ValidationError
+	var chaincodeErr *partnerlogic.ChaincodeError
+
+	if errors.As(err, &validationErr) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: validationErr.Message, Code: partnerlogic.CodeInvalidArgument, Field: validationErr.Field})
+	} else if errors.Is(err, ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: err.Error(), Code: partnerlogic.CodeNotFound})
+	} else if errors.As(err, &chaincodeErr) && chaincodeErr.Code != partnerlogic.CodeInternal {
+		writeJSON(w, int(chaincodeErr.Status()), ErrorResponse{Error: chaincodeErr.Message, Code: chaincodeErr.Code, Field: chaincodeErr.Field})
+	} else {
+		log.Printf("Backend error: %s", err)
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: err.Error()})
+	}
+}
+
+func (s *Server) createReferral(w http.ResponseWriter, r *http.Request, referralId string) {
+	referral, err := DecodeCreateReferral(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	txId, err := s.Backend.CreateReferral(referral)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/referrals/" + referral.ReferralId)
+	writeJSON(w, http.StatusAccepted, TxResponse{ReferralId: referral.ReferralId, TxId: txId})
+}
+
+func (s *Server) listReferrals(w http.ResponseWriter, r *http.Request, referralId string) {
+	status := r.URL.Query().Get("status")
+
+	if status != "" {
+		err := validStatus("status", status)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	referrals, err := s.Backend.ListReferrals(status)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, referrals)
+}
+
+func (s *Server) getReferral(w http.ResponseWriter, r *http.Request, referralId string) {
+	referral, err := s.Backend.GetReferral(referralId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, referral)
+}
+
+func (s *Server) updateStatus(w http.ResponseWriter, r *http.Request, referralId string) {
+	var request UpdateStatusRequest
+
+	err := decodeStrict(r.Body, &request)
+	if err == nil {
+		err = validStatus("status", request.Status)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	txId, err := s.Backend.UpdateStatus(referralId, request.Status)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, TxResponse{ReferralId: referralId, TxId: txId})
+}
+
+func (s *Server) updateMortgage(w http.ResponseWriter, r *http.Request, referralId string) {
+	var mortgage partnerlogic.Mortgage
+
+	err := decodeStrict(r.Body, &mortgage)
+	if err == nil {
+		err = required("amount", mortgage.Amount)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	txId, err := s.Backend.UpdateMortgage(referralId, mortgage)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, TxResponse{ReferralId: referralId, TxId: txId})
+}
+
+func (s *Server) closeDeal(w http.ResponseWriter, r *http.Request, referralId string) {
+	var request CloseDealRequest
+
+	err := decodeStrict(r.Body, &request)
+	if err == nil {
+		err = required("partner", request.Partner)
+	}
+	if err == nil {
+		err = required("dealCriteria", request.DealCriteria)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	txId, err := s.Backend.CloseDeal(referralId, request.Partner, request.DealCriteria)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, TxResponse{ReferralId: referralId, TxId: txId})
+}
+
+func (s *Server) syncPartner(w http.ResponseWriter, r *http.Request, referralId string) {
+	var request SyncPartnerRequest
+
+	err := decodeStrict(r.Body, &request)
+	if err == nil {
+		err = required("partner", request.Partner)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	txId, err := s.Backend.SyncPartner(referralId, request.Partner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, TxResponse{ReferralId: referralId, TxId: txId})
+}
+
+func (s *Server) stageDurations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	dimension := query.Get("by")
+	if dimension == "" {
+		dimension = partnerlogic.DimensionAll
+	}
+
+	report, err := s.Backend.StageDurations(dimension, query.Get("partner"), query.Get("from"), query.Get("to"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+
+// exportReferrals writes the referrals out as the backend reads them. Once the first one is written the
+// status can no longer report a failure, so the connection is dropped instead and the client sees the
+// export end early
+func (s *Server) exportReferrals(w http.ResponseWriter, r *http.Request) {
+	var exporter ExportWriter
+
+	query := r.URL.Query()
+	filter := ExportFilter{Status: query.Get("status"), From: query.Get("from"), To: query.Get("to")}
+
+	format := query.Get("format")
+	if format == "" {
+		format = ExportCSV
+	}
+
+	err := validExportFormat(format)
+	if err == nil {
+		err = filter.Validate()
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	start := func() error {
+		w.Header().Set("Content-Type", exportContentTypes[format])
+		w.WriteHeader(http.StatusOK)
+
+		var err error
+		exporter, err = NewExportWriter(w, format)
+		return err
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	err = s.Backend.ExportReferrals(filter, func(referral partnerlogic.CustomerReferral) error {
+		if exporter == nil {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := exporter.Write(referral)
+		if err == nil {
+			err = exporter.Flush()
+		}
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	})
+
+	// Nothing matched, which still gets the CSV header
+	if err == nil && exporter == nil {
+		err = start()
+		if err == nil {
+			err = exporter.Flush()
+		}
+	}
+
+	if err != nil && exporter == nil {
+		writeError(w, err)
+	} else if err != nil {
+		log.Printf("Export failed: %s", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) statement(w http.ResponseWriter, r *http.Request, partnerName string, period string) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != ExportCSV {
+		writeError(w, &ValidationError{Field: "format", Message: "must be one of json, csv"})
+		return
+	}
+
+	statement, err := s.Backend.Statement(partnerName, period)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if format != ExportCSV {
+		writeJSON(w, http.StatusOK, statement)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	err = WriteStatementCSV(w, statement)
+	if err != nil {
+		log.Printf("Writing the statement failed: %s", err)
+	}
+}
+
+func (s *Server) recordStatement(w http.ResponseWriter, r *http.Request, partnerName string, period string) {
+	txId, err := s.Backend.RecordStatement(partnerName, period)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, TxResponse{TxId: txId})
+}
\ No newline at end of file
@@ -0,0 +1,57 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// WriteStatementCSV writes a statement with a row per closed deal and reversal, followed by the net
+// total and the statement hash. Reversed compensation is negative so the column adds up to the total
+func WriteStatementCSV(w io.Writer, statement partnerlogic.Statement) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"type", "referralId", "bankReferralId", "branchId", "date", "dealCriteria", "customerSize", "compensation"})
+
+	for _, line := range statement.ClosedDeals {
+		cw.Write(statementRow("CLOSED", line, line.Compensation))
+	}
+	for _, line := range statement.Reversals {
+		cw.Write(statementRow("REVERSAL", line, -line.Compensation))
+	}
+
+	cw.Write([]string{"TOTAL", "", "", "", "", "", "", strconv.FormatInt(statement.Totals.NetCompensation, 10)})
+	cw.Write([]string{"HASH", "", "", "", "", "", "", statement.Hash})
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func statementRow(lineType string, line partnerlogic.StatementLine, compensation int64) []string {
+	return []string{
+		lineType,
+		line.ReferralId,
+		line.BankReferralId,
+		line.BranchId,
+		time.Unix(line.Timestamp, 0).UTC().Format(time.RFC3339),
+		line.DealCriteria,
+		line.CustomerSize,
+		strconv.FormatInt(compensation, 10),
+	}
+}
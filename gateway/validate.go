@@ -0,0 +1,226 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+// ValidationError reports a request field that failed validation
+type ValidationError struct {
+	Field string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+
+	return e.Field + ": " + e.Message
+}
+
+type CreateReferralRequest struct {
+	ReferralId string `json:"referralId"`
+	CustomerName string `json:"customerName"`
+	ContactNumber string `json:"contactNumber"`
+	CustomerId string `json:"customerId"`
+	EmployeeId string `json:"employeeId"`
+	BranchId string `json:"branchId"`
+	Departments []string `json:"departments"`
+	CreateDate int64 `json:"createDate"`
+	Status string `json:"status"`
+	Mortgage *partnerlogic.Mortgage `json:"mortgage"`
+	Consent *partnerlogic.Consent `json:"consent"`
+}
+
+type UpdateStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type CloseDealRequest struct {
+	Partner string `json:"partner"`
+	DealCriteria string `json:"dealCriteria"`
+}
+
+type SyncPartnerRequest struct {
+	Partner string `json:"partner"`
+}
+
+// decodeStrict decodes exactly one JSON value, rejecting fields the request type does not have
+func decodeStrict(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &ValidationError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}
+		}
+
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			return &ValidationError{Field: strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), "\""), Message: "unknown field"}
+		}
+
+		return &ValidationError{Message: "invalid JSON: " + err.Error()}
+	}
+
+	if decoder.More() {
+		return &ValidationError{Message: "invalid JSON: unexpected data after the request"}
+	}
+
+	return nil
+}
+
+// DecodeCreateReferral reads a create request, validates it and returns the referral to create
+func DecodeCreateReferral(body io.Reader) (partnerlogic.CustomerReferral, error) {
+	var request CreateReferralRequest
+
+	err := decodeStrict(body, &request)
+	if err != nil {
+		return partnerlogic.CustomerReferral{}, err
+	}
+
+	return request.toReferral()
+}
+
+func required(field string, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return &ValidationError{Field: field, Message: "is required"}
+	}
+
+	return nil
+}
+
+func validStatus(field string, status string) error {
+	for _, known := range partnerlogic.ReferralStatuses {
+		if status == known {
+			return nil
+		}
+	}
+
+	return &ValidationError{Field: field, Message: fmt.Sprintf("must be one of %s", strings.Join(partnerlogic.ReferralStatuses, ", "))}
+}
+
+// validConsent checks the consent captured with a referral, including when it was captured. Whether it covers
+// the partners the referral goes to is checked by the chaincode, which knows the partner registry
+func validConsent(consent *partnerlogic.Consent) error {
+	if consent == nil {
+		return &ValidationError{Field: "consent", Message: "is required"}
+	}
+
+	if len(consent.Scope) == 0 {
+		return &ValidationError{Field: "consent.scope", Message: "needs at least one partner"}
+	}
+
+	for i, partnerName := range consent.Scope {
+		err := required(fmt.Sprintf("consent.scope[%d]", i), partnerName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := required("consent.capturedBy", consent.CapturedBy)
+	if err != nil {
+		return err
+	}
+
+	known := false
+	for _, channel := range partnerlogic.ConsentChannels {
+		known = known || consent.Channel == channel
+	}
+
+	if !known {
+		return &ValidationError{Field: "consent.channel", Message: fmt.Sprintf("must be one of %s", strings.Join(partnerlogic.ConsentChannels, ", "))}
+	}
+
+	if consent.Timestamp <= 0 {
+		return &ValidationError{Field: "consent.timestamp", Message: "is required"}
+	}
+
+	return nil
+}
+
+// toReferral validates the request and fills in the defaults: a generated id, the initial status and the
+// current time as the create date
+func (r CreateReferralRequest) toReferral() (partnerlogic.CustomerReferral, error) {
+	var referral partnerlogic.CustomerReferral
+
+	for _, check := range []error{
+		required("customerName", r.CustomerName),
+		required("contactNumber", r.ContactNumber),
+		required("customerId", r.CustomerId),
+		required("employeeId", r.EmployeeId),
+	} {
+		if check != nil {
+			return referral, check
+		}
+	}
+
+	if len(r.Departments) == 0 {
+		return referral, &ValidationError{Field: "departments", Message: "needs at least one department"}
+	}
+
+	for i, department := range r.Departments {
+		err := required(fmt.Sprintf("departments[%d]", i), department)
+		if err != nil {
+			return referral, err
+		}
+	}
+
+	if r.ReferralId == "" {
+		r.ReferralId = newTxId()
+	}
+
+	if r.Status == "" {
+		r.Status = partnerlogic.InitialStatus
+	}
+
+	if r.Status != partnerlogic.InitialStatus {
+		return referral, &ValidationError{Field: "status", Message: "must be " + partnerlogic.InitialStatus + " for a new referral"}
+	}
+
+	if r.CreateDate == 0 {
+		r.CreateDate = time.Now().Unix()
+	}
+
+	err := validConsent(r.Consent)
+	if err != nil {
+		return referral, err
+	}
+
+	referral = partnerlogic.CustomerReferral{
+		ReferralId: r.ReferralId,
+		CustomerName: r.CustomerName,
+		ContactNumber: r.ContactNumber,
+		CustomerId: r.CustomerId,
+		EmployeeId: r.EmployeeId,
+		BranchId: r.BranchId,
+		Departments: r.Departments,
+		CreateDate: r.CreateDate,
+		Status: r.Status,
+		Mortgage: r.Mortgage,
+		Consent: r.Consent,
+	}
+
+	return referral, nil
+}
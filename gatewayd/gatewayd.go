@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// gatewayd serves the referral HTTP API. With -backend memory it runs the bank and partner chaincodes on
+// an in-memory ledger for local development, with -backend peer it calls the chaincodes through a peer's
+// Fabric Gateway service over TLS, signing as the identity in -cert and -key. The customers' PII keys are
+// read from the -pii-keys file, a JSON object of base64 keys by scope, and passed to the chaincodes as
+// transient data, as is the salt for customer hashes read from the -duplicate-salt file.
+//
+// The API does not authenticate its callers. Every request is signed as the one identity gatewayd runs
+// with and carries its PII keys, so anyone who can reach the API acts as that identity. gatewayd listens
+// on the loopback interface by default; to serve other hosts, put it behind a proxy that authenticates
+// the callers and only lets through those entitled to act as that identity
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"github.com/joerust/referral-partners/bank"
+	"github.com/joerust/referral-partners/gateway"
+	"github.com/joerust/referral-partners/partnerlogic"
+	"github.com/joerust/referral-partners/peerclient"
+)
+
+func main() {
+	var peerConfig peerclient.Config
+
+	listen := flag.String("listen", "127.0.0.1:8080", "address to serve the API on; the API does not authenticate its callers")
+	backendName := flag.String("backend", "memory", "backend to use: memory or peer")
+	flag.StringVar(&peerConfig.Endpoint, "peer", "localhost:7051", "peer gateway service address, for the peer backend")
+	flag.StringVar(&peerConfig.TLSCertPath, "tls-cert", "", "CA certificate of the peer's TLS certificate, for the peer backend")
+	flag.StringVar(&peerConfig.ServerName, "server-name", "", "name the peer's TLS certificate is checked against, if not the peer address")
+	flag.StringVar(&peerConfig.MspId, "msp", "", "MSP id of the identity to sign as, for the peer backend")
+	flag.StringVar(&peerConfig.CertPath, "cert", "", "certificate of the identity to sign as, for the peer backend")
+	flag.StringVar(&peerConfig.KeyPath, "key", "", "private key of the identity to sign as, for the peer backend")
+	flag.StringVar(&peerConfig.Channel, "channel", "", "channel the chaincodes run on, for the peer backend")
+	bankChaincode := flag.String("chaincode", "", "bank chaincode name, for the peer backend")
+	piiKeys := flag.String("pii-keys", "", "JSON file of PII keys by scope, for the peer backend")
+	duplicateSalt := flag.String("duplicate-salt", "", "file holding the salt for customer hashes, for the peer backend")
+	flag.Parse()
+
+	var backend gateway.Backend
+
+	switch *backendName {
+	case "memory":
+		localBackend, err := gateway.NewLocalBackend()
+		if err != nil {
+			log.Fatalf("Failed to set up the local ledger: %s", err)
+		}
+		backend = localBackend
+	case "peer":
+		if *bankChaincode == "" {
+			log.Fatalf("The peer backend needs -chaincode")
+		}
+
+		// The PII keys travel with every proposal, so the connection to the peer must be encrypted
+		if peerConfig.TLSCertPath == "" {
+			log.Fatalf("The peer backend needs -tls-cert")
+		}
+
+		if *duplicateSalt == "" {
+			log.Fatalf("The peer backend needs -duplicate-salt")
+		}
+
+		transient, err := readPIIKeys(*piiKeys)
+		if err != nil {
+			log.Fatalf("Failed to read the PII keys: %s", err)
+		}
+
+		salt, err := os.ReadFile(*duplicateSalt)
+		if err != nil {
+			log.Fatalf("Failed to read the duplicate salt: %s", err)
+		}
+		transient[bank.DuplicateSaltTransientName] = bytes.TrimSpace(salt)
+
+		client, err := peerclient.Connect(peerConfig)
+		if err != nil {
+			log.Fatalf("Failed to connect to the peer: %s", err)
+		}
+		defer client.Close()
+
+		backend = gateway.NewPeerBackend(client, *bankChaincode, transient)
+	default:
+		log.Fatalf("Unknown backend %s", *backendName)
+	}
+
+	log.Printf("Serving the referral API on %s with the %s backend", *listen, *backendName)
+	log.Fatal(http.ListenAndServe(*listen, gateway.NewServer(backend)))
+}
+
+// readPIIKeys returns the keys of a PII key file as the transient fields the chaincodes look them up in
+func readPIIKeys(path string) (map[string][]byte, error) {
+	transient := map[string][]byte{}
+	if path == "" {
+		return transient, nil
+	}
+
+	valAsbytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys map[string]string
+	err = json.Unmarshal(valAsbytes, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	for scope, key := range keys {
+		transient[partnerlogic.PIIKeyTransientPrefix + scope] = []byte(key)
+	}
+
+	return transient, nil
+}
\ No newline at end of file
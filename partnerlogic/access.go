@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The certificate attributes that identify a caller to the referral chaincodes
+const (
+	AttrRole = "referrals.role"
+	AttrPartner = "referrals.partner"
+	AttrEmployeeId = "referrals.employeeId"
+)
+
+// The roles a caller's certificate can carry. Analysts read referrals and their statistics for reporting
+const (
+	RoleBankEmployee = "bank.employee"
+	RoleBankManager = "bank.manager"
+	RolePartnerAgent = "partner.agent"
+	RolePartnerAdmin = "partner.admin"
+	RoleAuditor = "auditor"
+	RoleAnalyst = "analyst"
+)
+
+// The scopes the chaincode policies grant. ScopeAny lets a role call a function on any referral, the
+// policy supplies the check behind the others: ScopeOwnPartner limits partner roles to their partner,
+// ScopeOwnReferral limits bank employees to the referrals they made, ScopePartnerReferral limits
+// partner roles to the referrals forwarded to their partner and ScopeBankChaincode limits bank roles to
+// calls the bank chaincode makes on their behalf
+const (
+	ScopeAny = "ANY"
+	ScopeOwnPartner = "OWN_PARTNER"
+	ScopeOwnReferral = "OWN_REFERRAL"
+	ScopePartnerReferral = "PARTNER_REFERRAL"
+	ScopeBankChaincode = "BANK_CHAINCODE"
+)
+
+// Caller is the identity a transaction was submitted with. PartnerName is set for partner roles and
+// EmployeeId for bank roles
+type Caller struct {
+	Id string `json:"id"`
+	MspId string `json:"mspId"`
+	Role string `json:"role"`
+	PartnerName string `json:"partnerName,omitempty"`
+	EmployeeId string `json:"employeeId,omitempty"`
+}
+
+// GetCaller reads the caller's identity and role attributes from the transaction creator
+func GetCaller(stub shim.ChaincodeStubInterface) (Caller, error) {
+	var caller Caller
+	var err error
+
+	caller.Id, err = cid.GetID(stub)
+	if err != nil {
+		return caller, Forbidden("Could not read the caller's identity: " + err.Error())
+	}
+
+	caller.MspId, err = cid.GetMSPID(stub)
+	if err != nil {
+		return caller, Forbidden("Could not read the caller's MSP: " + err.Error())
+	}
+
+	for attribute, value := range map[string]*string{AttrRole: &caller.Role, AttrPartner: &caller.PartnerName, AttrEmployeeId: &caller.EmployeeId} {
+		*value, _, err = cid.GetAttributeValue(stub, attribute)
+		if err != nil {
+			return caller, Forbidden("Could not read attribute " + attribute + " of the caller: " + err.Error())
+		}
+	}
+
+	return caller, nil
+}
+
+// ScopeCheck decides whether a caller granted a scope may call a function with the given arguments,
+// typically by looking at the referral the arguments name
+type ScopeCheck func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error
+
+// Grants maps the roles allowed to call a function to the scope each is allowed
+type Grants map[string]string
+
+// AccessPolicy says which roles may call which function, and on which referrals. A function without
+// grants can not be called by anyone
+type AccessPolicy struct {
+	Grants map[string]Grants
+	Scopes map[string]ScopeCheck
+}
+
+// Authorize checks the caller of a function against the policy and returns the caller
+func (p AccessPolicy) Authorize(stub shim.ChaincodeStubInterface, function string, args []string) (Caller, error) {
+	caller, err := GetCaller(stub)
+	if err != nil {
+		return caller, err
+	}
+
+	if caller.Role == "" {
+		return caller, Forbidden("Caller " + caller.Id + " has no " + AttrRole + " attribute").WithDetail("function", function)
+	}
+
+	scope, granted := p.Grants[function][caller.Role]
+	if !granted {
+		return caller, Forbidden("Role " + caller.Role + " may not call " + function).WithDetail("function", function).WithDetail("role", caller.Role)
+	}
+
+	if scope == ScopeAny {
+		return caller, nil
+	}
+
+	check, found := p.Scopes[scope]
+	if !found {
+		return caller, Internal("The access policy has no scope " + scope, nil)
+	}
+
+	err = check(stub, caller, args)
+	if chaincodeErr, ok := err.(*ChaincodeError); ok && chaincodeErr.Code == CodeForbidden {
+		chaincodeErr.WithDetail("function", function).WithDetail("role", caller.Role).WithDetail("scope", scope)
+	}
+
+	return caller, err
+}
+
+// RequirePartner is a scope check letting partner roles act only for the partner named in their certificate
+func RequirePartner(caller Caller, partnerName string) error {
+	if caller.PartnerName == "" || caller.PartnerName != partnerName {
+		return Forbidden("Caller is not acting for partner " + partnerName)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partnerlogic
+
+import (
+	"strings"
+)
+
+// PartnerAdapter supplies the partner specific hooks used by PartnerChaincode
+type PartnerAdapter interface {
+	// PartnerName is the department name bank referrals use for this partner
+	PartnerName() string
+
+	// ValidateReferral rejects a referral before it is written to the ledger
+	ValidateReferral(referral PartnerReferral) error
+
+	// ComputeCompensation returns the commission paid when the referral closes with the given deal criteria
+	ComputeCompensation(referral PartnerReferral, dealCriteria string) (int64, error)
+
+	// MapFields converts a bank referral into the partner's own referral record
+	MapFields(referral CustomerReferral) (PartnerReferral, error)
+}
+
+// The adapters partner chaincodes and referral forwarding can use, keyed by partner name
+var adapters = map[string]PartnerAdapter{}
+
+func init() {
+	RegisterAdapter(VantivAdapter{})
+	RegisterAdapter(PaycorAdapter{})
+	RegisterAdapter(MortgageAdapter{})
+}
+
+// RegisterAdapter makes an adapter available under its partner name
+func RegisterAdapter(adapter PartnerAdapter) {
+	adapters[adapter.PartnerName()] = adapter
+}
+
+// AdapterFor returns the adapter registered for a partner, or nil if there is none
+func AdapterFor(partnerName string) PartnerAdapter {
+	return adapters[partnerName]
+}
+
+// TieredCommission looks up a commission from a table indexed by deal size (SMALL, MID, anything else)
+// and customer size (MICRO, SMALL, MID, anything else)
+func TieredCommission(closingCommission [3][4]int64, dealCriteria string, customerSize string) int64 {
+	var companySizeIndex, dealSizeIndex int
+
+	if dealCriteria == "SMALL" {
+		dealSizeIndex = 0
+	} else if dealCriteria == "MID" {
+		dealSizeIndex = 1
+	} else {
+		dealSizeIndex = 2
+	}
+
+	if customerSize == "MICRO" {
+		companySizeIndex = 0
+	} else if customerSize == "SMALL" {
+		companySizeIndex = 1
+	} else if customerSize == "MID" {
+		companySizeIndex = 2
+	} else {
+		companySizeIndex = 3
+	}
+
+	return closingCommission[dealSizeIndex][companySizeIndex]
+}
+
+// ValidatePartnerName rejects referrals addressed to a different partner
+func ValidatePartnerName(referral PartnerReferral, partnerName string) error {
+	if referral.PartnerName != "" && referral.PartnerName != partnerName {
+		return InvalidArgument("partnerName", "Referral " + referral.ReferralId + " is for partner " + referral.PartnerName + " not " + partnerName)
+	}
+
+	return nil
+}
+
+// MapBankReferral copies the fields shared by the bank and partner referral records
+func MapBankReferral(referral CustomerReferral, partnerName string) (PartnerReferral, error) {
+	var partnerReferral PartnerReferral
+
+	contactNumber, err := ParseContactNumber(referral.ContactNumber)
+	if err != nil {
+		return partnerReferral, err
+	}
+
+	partnerReferral.ReferralId = referral.ReferralId
+	partnerReferral.CustomerName = referral.CustomerName
+	partnerReferral.CustomerId = referral.CustomerId
+	partnerReferral.ContactNumber = contactNumber
+	partnerReferral.CreateDate = referral.CreateDate
+	partnerReferral.Status = referral.Status
+	partnerReferral.BranchId = referral.BranchId
+	partnerReferral.PartnerName = partnerName
+
+	return partnerReferral, nil
+}
+
+// ParseContactNumber turns a formatted phone number such as "(555) 123-4567" into the plain form partners
+// store, keeping its digits and a leading + so international numbers and leading zeros survive
+func ParseContactNumber(contactNumber string) (string, error) {
+	trimmed := strings.TrimSpace(contactNumber)
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, trimmed)
+
+	if digits == "" {
+		return "", nil
+	}
+
+	if len(digits) > 15 {
+		return "", InvalidArgument("contactNumber", "Invalid contact number " + contactNumber)
+	}
+
+	if strings.HasPrefix(trimmed, "+") {
+		return "+" + digits, nil
+	}
+
+	return digits, nil
+}
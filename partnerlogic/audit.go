@@ -0,0 +1,273 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Audit entries are kept under this prefix followed by the zero padded transaction time, so a range
+// query returns them in time order. The transaction id, the action and a digest of the subject and
+// referrals follow, so each entry of a transaction gets its own key
+const auditKeyPrefix = "AUDIT_"
+
+// The actions recorded in the audit log
+const (
+	AuditCustomerRedacted = "customerRedacted"
+	AuditReferralRedacted = "referralRedacted"
+	AuditConsentRevoked = "consentRevoked"
+	AuditPIIRead = "piiRead"
+)
+
+// The state of a PII field returned by auditedRead that was not masked
+const FieldClear = "CLEAR"
+
+// AuditEntry records who did what to which referrals. Subject is the customer id of the referrals,
+// whichever chaincode recorded the entry. For a read, Fields holds each PII field returned, either FieldClear
+// or the mask it was returned with
+type AuditEntry struct {
+	Action string `json:"action"`
+	Subject string `json:"subject,omitempty"`
+	ReferralIds []string `json:"referralIds,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Caller Caller `json:"caller"`
+	TxId string `json:"txId"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// RecordAudit stamps the entry with the caller and the transaction and writes it to the audit log
+func RecordAudit(entry AuditEntry, stub shim.ChaincodeStubInterface) error {
+	var err error
+
+	entry.Caller, err = GetCaller(stub)
+	if err != nil {
+		return err
+	}
+
+	entry.Timestamp, err = txTimeSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	entry.TxId = stub.GetTxID()
+
+	valAsbytes, err := json.Marshal(entry)
+	if err != nil {
+		return Internal("Failed to marshal the audit entry", err)
+	}
+
+	key := fmt.Sprintf("%s%020d_%s_%s_%s", auditKeyPrefix, entry.Timestamp, entry.TxId, entry.Action, auditDigest(entry))
+
+	err = stub.PutState(key, valAsbytes)
+	if err != nil {
+		return Internal("Failed to update state for " + key, err)
+	}
+
+	return nil
+}
+
+// auditDigest tells apart the entries one transaction records for the same action, such as the redaction
+// of each referral of a customer on a partner chaincode
+func auditDigest(entry AuditEntry) string {
+	digest := sha256.New()
+	for _, part := range append([]string{entry.Subject}, entry.ReferralIds...) {
+		digest.Write([]byte(part))
+		digest.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)[:8])
+}
+
+// ReadAuditLog - query function returning the audit entries, oldest first. Optional arguments limit
+// the entries to those from and before the given times, in seconds since the epoch
+func ReadAuditLog(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var from, to int64
+	var err error
+
+	fmt.Println("running readAuditLog()")
+
+	if len(args) > 2 {
+		return nil, WrongArgumentCount("at most the start and end times")
+	}
+
+	if len(args) > 0 && args[0] != "" {
+		from, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, InvalidArgument("from", "Invalid start time " + args[0])
+		}
+	}
+
+	endKey := auditKeyPrefix + "~"
+	if len(args) > 1 && args[1] != "" {
+		to, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, InvalidArgument("to", "Invalid end time " + args[1])
+		}
+		endKey = fmt.Sprintf("%s%020d", auditKeyPrefix, to)
+	}
+
+	entries, err := readAuditEntries(fmt.Sprintf("%s%020d", auditKeyPrefix, from), endKey, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(entries)
+}
+
+func readAuditEntries(startKey string, endKey string, stub shim.ChaincodeStubInterface) ([]AuditEntry, error) {
+	iterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, Internal("Failed to read the audit log", err)
+	}
+	defer iterator.Close()
+
+	entries := []AuditEntry{}
+	for iterator.HasNext() {
+		var entry AuditEntry
+
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, Internal("Failed to read the audit log", err)
+		}
+
+		err = json.Unmarshal(result.Value, &entry)
+		if err != nil {
+			return nil, Internal("Failed to read audit entry " + result.Key, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// NewRedaction records the current transaction and caller as the erasure of a referral's personal data
+func NewRedaction(stub shim.ChaincodeStubInterface) (*Redaction, error) {
+	caller, err := GetCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := txTimeSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redaction{Timestamp: timestamp, TxId: stub.GetTxID(), RedactedBy: caller.Id}, nil
+}
+
+// AuditedRead - invoke function returning a referral like the read query, with its PII revealed and
+// masked for the caller, and recording the PII fields returned in the audit log. Queries cannot write,
+// so this is the only function that reveals PII
+func AuditedRead(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var referral map[string]interface{}
+
+	fmt.Println("running auditedRead()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("1. the referral id")
+	}
+
+	if args[0] == configKey || strings.HasPrefix(args[0], auditKeyPrefix) {
+		return nil, InvalidArgument("referralId", args[0] + " is not a referral")
+	}
+
+	valAsbytes, err := Read(stub, args)
+	if err != nil {
+		return nil, err
+	}
+
+	valAsbytes, err = RevealPII(valAsbytes, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	valAsbytes, err = MaskResult(valAsbytes, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	masks, err := callerMasks(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &referral)
+	if err != nil {
+		return nil, Internal("Failed to read referral " + args[0], err)
+	}
+
+	fields := map[string]string{}
+	for _, field := range PIIFields {
+		value, found := referral[field]
+		if !found || value == "" || value == float64(0) || value == RedactedMarker {
+			continue
+		}
+
+		fields[field] = FieldClear
+		if mask, masked := masks[field]; masked {
+			fields[field] = mask
+		}
+	}
+
+	// Partner copies carry the bank's customer id too, so a customer's access report covers both sides
+	subject, _ := referral["customerId"].(string)
+
+	err = RecordAudit(AuditEntry{Action: AuditPIIRead, Subject: subject, ReferralIds: []string{args[0]}, Fields: fields}, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return valAsbytes, nil
+}
+
+// AccessReport - query function listing the audited reads of a customer's referrals, or the reads made
+// by an employee, oldest first
+func AccessReport(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running accessReport()")
+
+	if len(args) != 2 {
+		return nil, WrongArgumentCount("2. customer or employee and their id")
+	}
+
+	err := ValidateEnum("by", args[0], []string{"customer", "employee"})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := readAuditEntries(auditKeyPrefix, auditKeyPrefix + "~", stub)
+	if err != nil {
+		return nil, err
+	}
+
+	accesses := []AuditEntry{}
+	for _, entry := range entries {
+		if entry.Action != AuditPIIRead {
+			continue
+		}
+
+		if (args[0] == "customer" && entry.Subject == args[1]) || (args[0] == "employee" && entry.Caller.EmployeeId == args[1]) {
+			accesses = append(accesses, entry)
+		}
+	}
+
+	return json.Marshal(accesses)
+}
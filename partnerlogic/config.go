@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The ledger key holding the chaincode configuration
+const configKey = "CONFIG"
+
+// ChaincodeConfig is the configuration a chaincode is deployed with. It lives in world state rather than
+// on the chaincode struct so it survives the chaincode container being restarted. BankChaincodeName is
+// only used by partner chaincodes, and names the bank chaincode that forwards referrals to them. PIIKey is a
+// fallback for the key encrypting the partner's PII when none is passed in transient data. Every peer of
+// the channel can read it, so it is only accepted on a Development chaincode and never returned.
+// DuplicatePolicy and DuplicateSaltHash are only used by the bank chaincode to detect customers referred
+// twice; the salt itself is passed in transient data and only its hex SHA-256 is kept, so the salt
+// passed can be checked. ExpiryDays is the age past which referralStats counts an open referral as
+// expired. Masking replaces DefaultMaskingRules for the roles it names
+type ChaincodeConfig struct {
+	PartnerName string `json:"partnerName"`
+	BankChaincodeName string `json:"bankChaincodeName,omitempty"`
+	PIIKey string `json:"piiKey,omitempty"`
+	Development bool `json:"development,omitempty"`
+	DuplicatePolicy string `json:"duplicatePolicy,omitempty"`
+	DuplicateSaltHash string `json:"duplicateSaltHash,omitempty"`
+	ExpiryDays int `json:"expiryDays,omitempty"`
+	Masking MaskingRules `json:"masking,omitempty"`
+}
+
+// What the bank does with a referral for a customer that was already referred. Flagged duplicates are
+// recorded against the first referral, rejected ones are not recorded
+const (
+	DuplicateFlag = "FLAG"
+	DuplicateReject = "REJECT"
+)
+
+func (config ChaincodeConfig) validate() error {
+	if config.PartnerName == "" {
+		return InvalidArgument("partnerName", "The chaincode configuration needs a partnerName")
+	}
+
+	if config.PIIKey != "" && !config.Development {
+		return InvalidArgument("piiKey", "A PII key in the configuration is only accepted on a development chaincode")
+	}
+
+	if config.DuplicateSaltHash != "" {
+		hash, err := hex.DecodeString(config.DuplicateSaltHash)
+		if err != nil || len(hash) != sha256.Size {
+			return InvalidArgument("duplicateSaltHash", "The duplicateSaltHash must be the hex SHA-256 of the salt")
+		}
+	}
+
+	if config.ExpiryDays < 0 {
+		return InvalidArgument("expiryDays", "The expiryDays cannot be negative")
+	}
+
+	if config.DuplicatePolicy != "" {
+		err := ValidateEnum("duplicatePolicy", config.DuplicatePolicy, []string{DuplicateFlag, DuplicateReject})
+		if err != nil {
+			return err
+		}
+	}
+
+	return config.Masking.validate()
+}
+
+// redacted leaves out the PII key so the configuration can be returned
+func (config ChaincodeConfig) redacted() ChaincodeConfig {
+	config.PIIKey = ""
+	return config
+}
+
+// ParseInitConfig builds the configuration from the Init arguments. The arguments are either a JSON
+// configuration, which is applied over the defaults, or just the partner name
+func ParseInitConfig(args []string, defaults ChaincodeConfig) (ChaincodeConfig, error) {
+	config := defaults
+
+	if len(args) > 0 && strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		err := DecodeStrict(args[0], &config)
+		if err != nil {
+			return config, err
+		}
+	} else if len(args) > 0 {
+		config.PartnerName = args[0]
+	}
+
+	return config, config.validate()
+}
+
+// SaveConfig writes the configuration to the ledger
+func SaveConfig(config ChaincodeConfig, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	valAsbytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(configKey, valAsbytes)
+	if err != nil {
+		return nil, Internal("Failed to update state for " + configKey, err)
+	}
+
+	return json.Marshal(config.redacted())
+}
+
+// LoadConfig reads the configuration from the ledger. Functions that need it call this on each invocation
+func LoadConfig(stub shim.ChaincodeStubInterface) (ChaincodeConfig, error) {
+	var config ChaincodeConfig
+
+	valAsbytes, err := stub.GetState(configKey)
+	if err != nil {
+		return config, Internal("Failed to get state for " + configKey, err)
+	}
+
+	if valAsbytes == nil {
+		return config, Conflict("The chaincode has not been initialized")
+	}
+
+	err = json.Unmarshal(valAsbytes, &config)
+	if err != nil {
+		return config, err
+	}
+
+	return config, config.validate()
+}
+
+// UpdateConfig - admin invoke function to change the configuration. Only the fields present in the
+// JSON argument are changed
+func UpdateConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running updateConfig()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("1. the configuration fields to change")
+	}
+
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = DecodeStrict(args[0], &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return SaveConfig(config, stub)
+}
+
+// GetConfig - query function returning the configuration, without its secrets
+func GetConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(config.redacted())
+}
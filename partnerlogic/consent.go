@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"strconv"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The channels a customer's consent can be captured through
+var ConsentChannels = []string{"BRANCH", "PHONE", "ONLINE", "EMAIL"}
+
+// Consent is the customer's permission to share their details with the partners named in Scope. It is
+// captured by an employee when the referral is made. Partners are removed from Scope as the customer
+// revokes their consent, and each revocation is kept in Revocations
+type Consent struct {
+	Scope []string `json:"scope"`
+	Channel string `json:"channel"`
+	Timestamp int64 `json:"timestamp"`
+	CapturedBy string `json:"capturedBy"`
+	Revocations []ConsentRevocation `json:"revocations,omitempty"`
+}
+
+// ConsentRevocation records the partners a customer withdrew their consent from
+type ConsentRevocation struct {
+	Scope []string `json:"scope"`
+	Timestamp int64 `json:"timestamp"`
+	TxId string `json:"txId"`
+	RevokedBy string `json:"revokedBy"`
+}
+
+// Covers reports whether the customer consented to sharing with a partner
+func (c *Consent) Covers(partnerName string) bool {
+	if c == nil {
+		return false
+	}
+
+	for i := range c.Scope {
+		if c.Scope[i] == partnerName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Revoke removes the revoked partners from the scope and records the revocation
+func (c *Consent) Revoke(revocation ConsentRevocation) {
+	scope := []string{}
+
+	for _, partnerName := range c.Scope {
+		revoked := false
+		for i := range revocation.Scope {
+			if revocation.Scope[i] == partnerName {
+				revoked = true
+			}
+		}
+
+		if !revoked {
+			scope = append(scope, partnerName)
+		}
+	}
+
+	c.Scope = scope
+	c.Revocations = append(c.Revocations, revocation)
+}
+
+// ValidateConsent rejects a referral unless it carries consent, captured no later than the current
+// transaction, covering every partner serving one of its departments
+func ValidateConsent(referral CustomerReferral, stub shim.ChaincodeStubInterface) error {
+	consent := referral.Consent
+	if consent == nil {
+		return InvalidArgument("consent", "The customer's consent to share the referral with partners is required")
+	}
+
+	for _, err := range []error{
+		ValidateEnum("consent.channel", consent.Channel, ConsentChannels),
+		Required("consent.capturedBy", consent.CapturedBy),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	if consent.Timestamp <= 0 || consent.Timestamp > now {
+		return InvalidArgument("consent.timestamp", "Consent timestamp " + strconv.FormatInt(consent.Timestamp, 10) + " is not a time before the referral")
+	}
+
+	if len(consent.Revocations) > 0 {
+		return InvalidArgument("consent.revocations", "Revocations are recorded by revokeConsent")
+	}
+
+	for i := range referral.Departments {
+		partner, err := FindPartnerForDepartment(referral.Departments[i], stub)
+		if err != nil {
+			return err
+		}
+
+		if partner != nil && !consent.Covers(partner.PartnerName) {
+			return InvalidArgument("consent.scope", "The customer did not consent to sharing with partner " + partner.PartnerName).WithDetail("department", referral.Departments[i])
+		}
+	}
+
+	return nil
+}
+
+// RequireConsent refuses to share a referral with a partner the customer has not consented to
+func RequireConsent(referralId string, referral CustomerReferral, partnerName string) error {
+	if !referral.Consent.Covers(partnerName) {
+		return Forbidden("The customer of referral " + referralId + " has not consented to sharing with partner " + partnerName)
+	}
+
+	return nil
+}
+
+// NewConsentRevocation records the current transaction and caller as revoking consent for the scope
+func NewConsentRevocation(scope []string, stub shim.ChaincodeStubInterface) (ConsentRevocation, error) {
+	caller, err := GetCaller(stub)
+	if err != nil {
+		return ConsentRevocation{}, err
+	}
+
+	timestamp, err := txTimeSeconds(stub)
+	if err != nil {
+		return ConsentRevocation{}, err
+	}
+
+	return ConsentRevocation{Scope: scope, Timestamp: timestamp, TxId: stub.GetTxID(), RevokedBy: caller.Id}, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// ChaincodeFunction is an invoke or query function of a chaincode
+type ChaincodeFunction func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+// Functions maps function names to their implementation
+type Functions map[string]ChaincodeFunction
+
+// Dispatch runs the function named in the transaction once the policy has authorized the caller. Query
+// functions are handed a stub that refuses to write, so a query can never change the ledger even if it
+// is submitted as a transaction, and their results are masked for the caller's role. The referral
+// changes an invoke function emits are published as one chaincode event when it succeeds, and its
+// status index moves are written then, once per index
+func Dispatch(stub shim.ChaincodeStubInterface, invokes Functions, queries Functions, policy AccessPolicy) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+
+	if query, found := queries[function]; found {
+		fmt.Println("query is running " + function)
+		return authorizeAndRun(ReadOnlyStub{stub}, function, args, MaskedQuery(query), policy)
+	}
+
+	if invoke, found := invokes[function]; found {
+		fmt.Println("invoke is running " + function)
+		transaction := &transactionStub{ChaincodeStubInterface: stub}
+
+		response := authorizeAndRun(transaction, function, args, invoke, policy)
+		if response.Status != shim.OK {
+			return response
+		}
+
+		err := transaction.writeStatusIndexes()
+		if err == nil {
+			err = transaction.publish()
+		}
+		if err != nil {
+			return Respond(nil, err)
+		}
+
+		return response
+	}
+
+	fmt.Println("invoke did not find func: " + function)
+
+	return Respond(nil, InvalidArgument("function", "Received unknown function invocation " + function))
+}
+
+func authorizeAndRun(stub shim.ChaincodeStubInterface, function string, args []string, run ChaincodeFunction, policy AccessPolicy) pb.Response {
+	caller, err := policy.Authorize(stub, function, args)
+	if err != nil {
+		fmt.Println("denied " + function + " to " + caller.Id)
+		return Respond(nil, err)
+	}
+
+	return Respond(run(stub, args))
+}
+
+// Respond turns a function's result into a chaincode response. Failures are reported as a ChaincodeError
+// with the status matching its code
+func Respond(payload []byte, err error) pb.Response {
+	if err != nil {
+		chaincodeErr := ToChaincodeError(err)
+		return pb.Response{Status: chaincodeErr.Status(), Message: chaincodeErr.Error()}
+	}
+
+	return shim.Success(payload)
+}
+
+// ReadOnlyStub is the stub query functions run with. Reads, including reads through other chaincodes,
+// pass through; writes and events fail
+type ReadOnlyStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func readOnlyError() error {
+	return Forbidden("Query functions cannot change the ledger")
+}
+
+func (s ReadOnlyStub) PutState(key string, value []byte) error {
+	return readOnlyError()
+}
+
+func (s ReadOnlyStub) DelState(key string) error {
+	return readOnlyError()
+}
+
+func (s ReadOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	return readOnlyError()
+}
+
+func (s ReadOnlyStub) DelPrivateData(collection string, key string) error {
+	return readOnlyError()
+}
+
+func (s ReadOnlyStub) SetEvent(name string, payload []byte) error {
+	return readOnlyError()
+}
+
+// CallChaincode calls a function of another chaincode on the same channel and returns its payload.
+// A failure is returned as the ChaincodeError the other chaincode reported
+func CallChaincode(chaincodeName string, function string, args []string, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	invokeArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+
+	response := stub.InvokeChaincode(chaincodeName, invokeArgs, "")
+	if response.Status != shim.OK {
+		return nil, ToChaincodeError(errors.New(response.Message)).WithDetail("chaincode", chaincodeName)
+	}
+
+	return response.Payload, nil
+}
+
+// calledByBank reports whether a partner chaincode is running because the bank chaincode called it. A
+// called chaincode sees the proposal of the transaction, which names the chaincode it was submitted to
+func calledByBank(stub shim.ChaincodeStubInterface) (bool, error) {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return false, err
+	}
+
+	if config.BankChaincodeName == "" {
+		return false, nil
+	}
+
+	signedProposal, err := stub.GetSignedProposal()
+	if err != nil {
+		return false, Internal("Failed to get the transaction proposal", err)
+	}
+
+	var proposal pb.Proposal
+	var payload pb.ChaincodeProposalPayload
+	var invocation pb.ChaincodeInvocationSpec
+
+	err = proto.Unmarshal(signedProposal.GetProposalBytes(), &proposal)
+	if err == nil {
+		err = proto.Unmarshal(proposal.Payload, &payload)
+	}
+	if err == nil {
+		err = proto.Unmarshal(payload.Input, &invocation)
+	}
+	if err != nil {
+		return false, Internal("Failed to decode the transaction proposal", err)
+	}
+
+	return invocation.GetChaincodeSpec().GetChaincodeId().GetName() == config.BankChaincodeName, nil
+}
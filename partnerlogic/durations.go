@@ -0,0 +1,250 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// TimeToClose is the stage reported for the whole time from a referral's creation until it closed
+const TimeToClose = "CREATED:CLOSED"
+
+// StageDuration aggregates the seconds referrals spent in a stage. A stage is named by the status left
+// and the status entered, such as ACTIVE:PENDING
+type StageDuration struct {
+	Stage string `json:"stage"`
+	Count int `json:"count"`
+	Median int64 `json:"median"`
+	P90 int64 `json:"p90"`
+	Max int64 `json:"max"`
+}
+
+// DurationGroup holds the stage durations of one dimension value
+type DurationGroup struct {
+	Value string `json:"value"`
+	Stages []StageDuration `json:"stages"`
+}
+
+// DurationReport is the result of stageDurations. From and To are inclusive days, empty when unbounded
+type DurationReport struct {
+	Dimension string `json:"dimension"`
+	From string `json:"from,omitempty"`
+	To string `json:"to,omitempty"`
+	Groups []DurationGroup `json:"groups"`
+}
+
+// ReferralStages is a referral's status history and the dimension values it is reported under
+type ReferralStages struct {
+	History []StatusChange
+	Keys []StatKey
+}
+
+// ReadReferralStages reads the stages of a referral from its ledger JSON
+type ReadReferralStages func(valAsbytes []byte, stub shim.ChaincodeStubInterface) (ReferralStages, error)
+
+// CustomerReferralStages reads the stages of a bank referral
+func CustomerReferralStages(valAsbytes []byte, stub shim.ChaincodeStubInterface) (ReferralStages, error) {
+	var referral CustomerReferral
+
+	err := json.Unmarshal(valAsbytes, &referral)
+	if err != nil {
+		return ReferralStages{}, err
+	}
+
+	keys, err := customerReferralStatKeys(referral, stub)
+	return ReferralStages{History: referral.StatusHistory, Keys: keys}, err
+}
+
+// PartnerReferralStages reads the stages of a partner referral
+func PartnerReferralStages(valAsbytes []byte, stub shim.ChaincodeStubInterface) (ReferralStages, error) {
+	var referral PartnerReferral
+
+	err := json.Unmarshal(valAsbytes, &referral)
+	if err != nil {
+		return ReferralStages{}, err
+	}
+
+	keys, err := partnerReferralStatKeys(referral, stub)
+	return ReferralStages{History: referral.StatusHistory, Keys: keys}, err
+}
+
+// ParseDayRange turns inclusive YYYY-MM-DD days into the first and last second they cover. An empty day
+// leaves that end of the range open, as 0
+func ParseDayRange(from string, to string) (int64, int64, error) {
+	var start, end int64
+
+	if from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return 0, 0, InvalidArgument("from", "Invalid day " + from + ", expecting YYYY-MM-DD")
+		}
+		start = day.Unix()
+	}
+
+	if to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return 0, 0, InvalidArgument("to", "Invalid day " + to + ", expecting YYYY-MM-DD")
+		}
+		end = day.AddDate(0, 0, 1).Unix() - 1
+	}
+
+	return start, end, nil
+}
+
+// AggregateStageDurations groups the stages referrals went through by the values of a dimension. Only
+// stages that ended between start and end, in seconds with 0 leaving an end open, are counted
+func AggregateStageDurations(dimension string, start int64, end int64, referrals []ReferralStages) []DurationGroup {
+	durations := map[string]map[string][]int64{}
+
+	for _, referral := range referrals {
+		history := referral.History
+
+		for _, key := range referral.Keys {
+			if key.Dimension != dimension {
+				continue
+			}
+
+			for i := 1; i < len(history); i++ {
+				entered := history[i].Timestamp
+				if entered < start || (end != 0 && entered > end) {
+					continue
+				}
+
+				if durations[key.Value] == nil {
+					durations[key.Value] = map[string][]int64{}
+				}
+				stages := durations[key.Value]
+
+				stage := history[i - 1].Status + ":" + history[i].Status
+				stages[stage] = append(stages[stage], entered - history[i - 1].Timestamp)
+
+				if history[i].Status == "CLOSED" {
+					stages[TimeToClose] = append(stages[TimeToClose], entered - history[0].Timestamp)
+				}
+			}
+		}
+	}
+
+	groups := []DurationGroup{}
+	for value, stages := range durations {
+		group := DurationGroup{Value: value, Stages: []StageDuration{}}
+		for stage, seconds := range stages {
+			group.Stages = append(group.Stages, stageDuration(stage, seconds))
+		}
+
+		sort.Slice(group.Stages, func(i, j int) bool {
+			return group.Stages[i].Stage < group.Stages[j].Stage
+		})
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Value < groups[j].Value
+	})
+
+	return groups
+}
+
+func stageDuration(stage string, seconds []int64) StageDuration {
+	sort.Slice(seconds, func(i, j int) bool {
+		return seconds[i] < seconds[j]
+	})
+
+	return StageDuration{
+		Stage: stage,
+		Count: len(seconds),
+		Median: percentile(seconds, 50),
+		P90: percentile(seconds, 90),
+		Max: seconds[len(seconds) - 1],
+	}
+}
+
+// Nearest rank percentile of sorted values
+func percentile(sorted []int64, p int) int64 {
+	rank := (p * len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank - 1]
+}
+
+// StageDurationsQuery returns the stageDurations query function of a chaincode storing referrals that
+// readStages understands. The query takes the dimension and optionally the first and last day, as
+// YYYY-MM-DD, the stages must have ended in
+func StageDurationsQuery(readStages ReadReferralStages) ChaincodeFunction {
+	return func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+		var report DurationReport
+		var referrals []ReferralStages
+
+		fmt.Println("running stageDurations()")
+
+		if len(args) < 1 || len(args) > 3 {
+			return nil, WrongArgumentCount("the dimension and optionally the first and last day")
+		}
+
+		report.Dimension = args[0]
+		err := ValidateEnum("dimension", report.Dimension, StatDimensions)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(args) > 1 {
+			report.From = args[1]
+		}
+		if len(args) > 2 {
+			report.To = args[2]
+		}
+
+		start, end, err := ParseDayRange(report.From, report.To)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, status := range ReferralStatuses {
+			valAsbytes, err := stub.GetState(status)
+			if err != nil {
+				return nil, Internal("Failed to get state for " + status, err)
+			}
+
+			for _, referralId := range SplitReferralIds(BytesToString(valAsbytes)) {
+				valAsbytes, err := stub.GetState(referralId)
+				if err != nil {
+					return nil, Internal("Failed to get state for " + referralId, err)
+				}
+
+				if valAsbytes == nil {
+					continue
+				}
+
+				stages, err := readStages(valAsbytes, stub)
+				if err != nil {
+					return nil, Internal("Failed to read referral " + referralId, err)
+				}
+
+				referrals = append(referrals, stages)
+			}
+		}
+
+		report.Groups = AggregateStageDurations(report.Dimension, start, end, referrals)
+
+		return json.Marshal(report)
+	}
+}
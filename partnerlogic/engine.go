@@ -0,0 +1,525 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// PartnerChaincode stores and updates a partner's referrals on the blockchain. Everything that
+// differs between partners is delegated to the Adapter
+type PartnerChaincode struct {
+	Adapter PartnerAdapter
+}
+
+func NewPartnerChaincode(adapter PartnerAdapter) *PartnerChaincode {
+	return &PartnerChaincode{Adapter: adapter}
+}
+
+// Init resets all the things
+func (t *PartnerChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+	return Respond(t.init(stub, args))
+}
+
+func (t *PartnerChaincode) init(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	// Initialize the partner names
+	config, err := ParseInitConfig(args, ChaincodeConfig{PartnerName: t.Adapter.PartnerName()})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Initializing chaincode for partner: " + config.PartnerName)
+	return SaveConfig(config, stub)
+}
+
+// Invoke is our entry point to invoke a chaincode function. Callers are authorized by PartnerPolicy and
+// query functions are dispatched with a read only stub
+func (t *PartnerChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return Dispatch(stub, Functions{
+		"init": t.init,
+		"createReferral": t.createReferral,
+		"updateReferralStatus": t.updateReferralStatus,
+		"closeReferredDeal": t.closeReferredDeal,
+		"updateConfig": UpdateConfig,
+		"redactReferral": t.redactReferral,
+		"revokeConsent": t.revokeConsent,
+		"auditedRead": AuditedRead,
+		"recordStatement": RecordStatement,
+	}, Functions{
+		"read": Read,
+		"searchByStatus": SearchByStatusQuery,
+		"readAllReferrals": t.readAllReferrals,
+		"getConfig": GetConfig,
+		"readAuditLog": ReadAuditLog,
+		"accessReport": AccessReport,
+		"referralStats": ReferralStatsQuery(PartnerReferralStages),
+		"stageDurations": StageDurationsQuery(PartnerReferralStages),
+		"readStatement": ReadStatement,
+	}, PartnerPolicy)
+}
+
+// PartnerPolicy is the access policy of the partner chaincodes. Partner roles act only on the chaincode
+// of the partner named in their certificate. Through its chaincode only, the bank creates referrals by
+// forwarding them, declines them when the customer revokes consent and, for its managers, redacts them
+// when a customer's data is erased. Its managers and auditors read them to reconcile. Analysts read the
+// referrals and their statistics. Partner admins and bank managers both record the monthly statement, so
+// each confirms the same figures
+var PartnerPolicy = AccessPolicy{
+	Grants: map[string]Grants{
+		"init": {RolePartnerAdmin: ScopeOwnPartner},
+		"updateConfig": {RolePartnerAdmin: ScopeOwnPartner},
+		"createReferral": {RoleBankEmployee: ScopeBankChaincode, RoleBankManager: ScopeBankChaincode},
+		"updateReferralStatus": {RolePartnerAgent: ScopeOwnPartner, RolePartnerAdmin: ScopeOwnPartner},
+		"closeReferredDeal": {RolePartnerAdmin: ScopeOwnPartner},
+		"read": {RolePartnerAgent: ScopeOwnPartner, RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny, RoleAnalyst: ScopeAny},
+		"searchByStatus": {RolePartnerAgent: ScopeOwnPartner, RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny, RoleAnalyst: ScopeAny},
+		"readAllReferrals": {RolePartnerAgent: ScopeOwnPartner, RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny, RoleAnalyst: ScopeAny},
+		"getConfig": {RolePartnerAgent: ScopeOwnPartner, RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny},
+		"redactReferral": {RoleBankManager: ScopeBankChaincode},
+		"revokeConsent": {RoleBankEmployee: ScopeBankChaincode, RoleBankManager: ScopeBankChaincode},
+		"readAuditLog": {RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny},
+		"auditedRead": {RolePartnerAgent: ScopeOwnPartner, RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny, RoleAnalyst: ScopeAny},
+		"accessReport": {RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny},
+		"referralStats": {RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny, RoleAnalyst: ScopeAny},
+		"stageDurations": {RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny, RoleAnalyst: ScopeAny},
+		"readStatement": {RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny, RoleAuditor: ScopeAny},
+		"recordStatement": {RolePartnerAdmin: ScopeOwnPartner, RoleBankManager: ScopeAny},
+	},
+	Scopes: map[string]ScopeCheck{
+		ScopeOwnPartner: ownPartnerChaincode,
+		ScopeBankChaincode: bankChaincodeCall,
+	},
+}
+
+func ownPartnerChaincode(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	return RequirePartner(caller, config.PartnerName)
+}
+
+// The bank forwards, revokes and redacts referrals through its own chaincode, which checks the customer's
+// consent first, so bank roles may not call those functions on a partner directly
+func bankChaincodeCall(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+	called, err := calledByBank(stub)
+	if err != nil {
+		return err
+	}
+
+	if !called {
+		return Forbidden("Only the bank chaincode may call this function")
+	}
+
+	return nil
+}
+
+func (t *PartnerChaincode) readAllReferrals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	var allReferralsAsbytes []byte
+	var allReferrals []PartnerReferral
+
+	for _, status := range ReferralStatuses {
+		var referrals []PartnerReferral
+
+		fmt.Println("Reading " + status + " referrals")
+		statusesAsBytes, err := SearchByStatus(status, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Println("Unmarshalling " + status + " referrals")
+		err = json.Unmarshal(statusesAsBytes, &referrals)
+		if err != nil {
+			return nil, Internal("Failed to read the " + status + " referrals", err)
+		}
+
+		fmt.Println("Appending " + status + " referrals")
+		allReferrals = append(allReferrals, referrals...)
+	}
+
+	fmt.Println("Marshalling all referrals")
+	allReferralsAsbytes, err = json.Marshal(allReferrals)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil, Internal("Failed to marshal the referrals", err)
+	}
+
+	fmt.Println("Returing " + strconv.Itoa(len(allReferrals)) + " referrals")
+	return allReferralsAsbytes, nil
+}
+
+func (t *PartnerChaincode) closeReferredDeal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var referralId, dealCriteria string
+	var err error
+	var referral PartnerReferral
+	var referralAsBytes []byte
+	var compensation int64
+
+	fmt.Println("running closeReferredDeal()")
+
+	if len(args) != 2 {
+		return nil, WrongArgumentCount("2. name of the key and value to set")
+	}
+
+	referralId = args[0] // The referral id
+	dealCriteria = args[1] // The new deal criteria
+
+	// Look up the referral that matches the current referral id
+	referral, err = GetPartnerReferral(referralId, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the current status so that it can be unindexed once we update the referral object
+	oldStatus := referral.Status
+
+	// Set the referral status to the new value
+	referral.Status = "CLOSED"
+	referral.DealCriteria = dealCriteria
+
+	referral.StatusHistory, err = RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CountPartnerReferral(referralId, referral, oldStatus, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	compensation, err = t.Adapter.ComputeCompensation(referral, dealCriteria)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Paying out a commission of: " + strconv.FormatInt(compensation, 10))
+
+	referral.Compensation = &compensation
+
+	closing := &referral.StatusHistory[len(referral.StatusHistory) - 1]
+	closing.DealCriteria = dealCriteria
+	closing.Compensation = &compensation
+
+	// Serialize the object to a JSON string to be stored in the ledger
+	referralAsBytes, err = json.Marshal(referral)
+	if err != nil {
+		return nil, Internal("Failed to marshal referral " + referralId, err)
+	}
+
+	// Store the json string in the ledger
+	err = stub.PutState(referralId, referralAsBytes) //write the variable into the chaincode state
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Move the referral to the index of its new status
+	err = ReindexByStatus(referralId, oldStatus, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = EmitPartnerReferralEvent(EventReferralClosed, referralId, oldStatus, referral, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return referralAsBytes, nil
+}
+
+// updateReferral - invoke function to updateReferral key/value pair
+func (t *PartnerChaincode) updateReferralStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var key, value string
+	var err error
+	var referral PartnerReferral
+	var valAsbytes []byte
+
+	fmt.Println("running updateReferral()")
+
+	if len(args) != 2 {
+		return nil, WrongArgumentCount("2. name of the key and value to set")
+	}
+
+	key = args[0] // The referral id
+	value = args[1] // The new status
+
+	err = ValidateEnum("status", value, ReferralStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look up the referral that matches the current referral id
+	referral, err = GetPartnerReferral(key, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the current status so that it can be unindexed once we update the referral object
+	oldStatus := referral.Status
+
+	// Set the referral status to the new value
+	referral.Status = value
+
+	referral.StatusHistory, err = RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CountPartnerReferral(key, referral, oldStatus, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serialize the object to a JSON string to be stored in the ledger
+	valAsbytes, err = json.Marshal(referral)
+	if err != nil {
+		return nil, Internal("Failed to marshal referral " + key, err)
+	}
+
+	// Store the json string in the ledger
+	err = stub.PutState(key, valAsbytes) //write the variable into the chaincode state
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Move the referral to the index of its new status
+	err = ReindexByStatus(key, oldStatus, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = EmitPartnerReferralEvent(EventReferralStatusUpdated, key, oldStatus, referral, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return valAsbytes, nil
+}
+
+// redactReferral - invoke function called by the bank chaincode when a customer's personal data is erased.
+// The name and contact number are dropped while the status history and compensation are kept
+func (t *PartnerChaincode) redactReferral(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var err error
+	var referral PartnerReferral
+	var valAsbytes []byte
+
+	fmt.Println("running redactReferral()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("1. the referral id")
+	}
+
+	key := args[0]
+
+	referral, err = GetPartnerReferral(key, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if referral.Redaction == nil {
+		referral.Redaction, err = NewRedaction(stub)
+		if err != nil {
+			return nil, err
+		}
+
+		referral.CustomerName = RedactedMarker
+		referral.ContactNumber = RedactedMarker
+		referral.PII = nil
+
+		valAsbytes, err = json.Marshal(referral)
+		if err != nil {
+			return nil, Internal("Failed to marshal referral " + key, err)
+		}
+
+		err = stub.PutState(key, valAsbytes)
+		if err != nil {
+			return nil, Internal("Failed to update state for " + key, err)
+		}
+
+		err = RecordAudit(AuditEntry{Action: AuditReferralRedacted, Subject: referral.CustomerId, ReferralIds: []string{key}}, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(referral)
+}
+
+// revokeConsent - invoke function called by the bank chaincode when the customer withdraws their consent
+// to share the referrals named in the arguments with this partner. Open referrals are declined. Answers
+// with the referrals this partner has by id. The bank declines its own copies, so the change is not
+// synced back
+func (t *PartnerChaincode) revokeConsent(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running revokeConsent()")
+
+	if len(args) == 0 {
+		return nil, WrongArgumentCount("the ids of the referrals to revoke consent on")
+	}
+
+	referrals := map[string]PartnerReferral{}
+	for _, key := range args {
+		referral, err := GetPartnerReferral(key, stub)
+		if err != nil && ToChaincodeError(err).Code == CodeNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		referral, err = revokePartnerReferralConsent(key, referral, stub)
+		if err != nil {
+			return nil, err
+		}
+
+		referrals[key] = referral
+	}
+
+	return json.Marshal(referrals)
+}
+
+// Declines the referral if it is still open
+func revokePartnerReferralConsent(key string, referral PartnerReferral, stub shim.ChaincodeStubInterface) (PartnerReferral, error) {
+	var err error
+
+	oldStatus := referral.Status
+	if !IsOpenStatus(oldStatus) {
+		return referral, nil
+	}
+
+	referral.Status = "DECLINED"
+
+	referral.StatusHistory, err = RecordStatusChange(referral.StatusHistory, referral.Status, stub)
+	if err != nil {
+		return referral, err
+	}
+
+	err = CountPartnerReferral(key, referral, oldStatus, stub)
+	if err != nil {
+		return referral, err
+	}
+
+	valAsbytes, err := json.Marshal(referral)
+	if err != nil {
+		return referral, Internal("Failed to marshal referral " + key, err)
+	}
+
+	err = stub.PutState(key, valAsbytes)
+	if err != nil {
+		return referral, Internal("Failed to update state for " + key, err)
+	}
+
+	err = ReindexByStatus(key, oldStatus, referral.Status, stub)
+	if err != nil {
+		return referral, err
+	}
+
+	err = EmitPartnerReferralEvent(EventReferralStatusUpdated, key, oldStatus, referral, stub)
+	if err != nil {
+		return referral, err
+	}
+
+	return referral, nil
+}
+
+// createReferral - invoke function to write key/value pair
+func (t *PartnerChaincode) createReferral(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var referralKey, referralData string
+	var err error
+	fmt.Println("running createReferral()")
+
+	if len(args) != 2 {
+		return nil, WrongArgumentCount("2 parameters, name of the key and value to set")
+	}
+
+	referralKey = args[0] //rename for funsies
+	referralData = args[1]
+
+	// Deserialize the input string into a GO data structure to hold the referral
+	var referral PartnerReferral
+
+	// Nothing is written unless the referral is valid
+	err = DecodeStrict(referralData, &referral)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidatePartnerReferral(referralKey, referral)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partner referrals are only created by the bank forwarding its own referral under the same key
+	referral.Status = InitialStatus
+	referral.BankReferralId = referralKey
+
+	err = EnsureNewReferral(referralKey, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Let the partner reject the referral before anything is written
+	err = t.Adapter.ValidateReferral(referral)
+	if err != nil {
+		return nil, err
+	}
+
+	referral.StatusHistory, err = RecordStatusChange(nil, referral.Status, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CountPartnerReferral(referralKey, referral, "", stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the partner's own key can read the customer's details back
+	err = SealPartnerReferral(referralKey, &referral, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	valAsbytes, err := json.Marshal(referral)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(referralKey, valAsbytes) //write the variable into the chaincode state
+	if err != nil {
+		return nil, err
+	}
+
+	err = IndexByStatus(referralKey, referral.Status, stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = EmitPartnerReferralEvent(EventReferralCreated, referralKey, "", referral, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return valAsbytes, nil
+}
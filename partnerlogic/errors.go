@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// The codes a ChaincodeError can carry
+const (
+	CodeNotFound = "NOT_FOUND"
+	CodeInvalidArgument = "INVALID_ARGUMENT"
+	CodeConflict = "CONFLICT"
+	CodeForbidden = "FORBIDDEN"
+	CodeInternal = "INTERNAL"
+)
+
+// ChaincodeError is how every chaincode function reports failure. It travels as the JSON message of the
+// error response, so clients and calling chaincodes can branch on Code. Field names the argument or
+// referral field at fault, and Details carries any other values a client may need
+type ChaincodeError struct {
+	Code string `json:"code"`
+	Message string `json:"message"`
+	Field string `json:"field,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	valAsbytes, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+
+	return string(valAsbytes)
+}
+
+// WithDetail adds a detail to the error and returns it
+func (e *ChaincodeError) WithDetail(name string, value string) *ChaincodeError {
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	e.Details[name] = value
+
+	return e
+}
+
+// Status is the response status the error is reported with
+func (e *ChaincodeError) Status() int32 {
+	switch e.Code {
+	case CodeInvalidArgument:
+		return 400
+	case CodeForbidden:
+		return 403
+	case CodeNotFound:
+		return 404
+	case CodeConflict:
+		return 409
+	}
+
+	return 500
+}
+
+// NotFound reports a referral, partner or other record that does not exist
+func NotFound(message string) *ChaincodeError {
+	return &ChaincodeError{Code: CodeNotFound, Message: message}
+}
+
+// InvalidArgument reports a bad argument. field may be empty when the problem is not with one field
+func InvalidArgument(field string, message string) *ChaincodeError {
+	return &ChaincodeError{Code: CodeInvalidArgument, Message: message, Field: field}
+}
+
+// Conflict reports a request that is valid but clashes with the current state of the ledger
+func Conflict(message string) *ChaincodeError {
+	return &ChaincodeError{Code: CodeConflict, Message: message}
+}
+
+// Forbidden reports a caller that is not allowed to do what it asked
+func Forbidden(message string) *ChaincodeError {
+	return &ChaincodeError{Code: CodeForbidden, Message: message}
+}
+
+// Internal reports a failure of the ledger or of the chaincode itself
+func Internal(message string, cause error) *ChaincodeError {
+	internalErr := &ChaincodeError{Code: CodeInternal, Message: message}
+	if cause != nil {
+		internalErr.WithDetail("cause", cause.Error())
+	}
+
+	return internalErr
+}
+
+// WrongArgumentCount reports a call with the wrong number of arguments
+func WrongArgumentCount(expecting string) *ChaincodeError {
+	return InvalidArgument("", "Incorrect number of arguments. Expecting " + expecting)
+}
+
+// ParseError reads a ChaincodeError back from an error response message
+func ParseError(message string) (*ChaincodeError, bool) {
+	var chaincodeErr ChaincodeError
+
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") {
+		return nil, false
+	}
+
+	if json.Unmarshal([]byte(message), &chaincodeErr) != nil || chaincodeErr.Code == "" {
+		return nil, false
+	}
+
+	return &chaincodeErr, true
+}
+
+// ToChaincodeError returns err as a ChaincodeError, reporting errors that are not one as INTERNAL
+func ToChaincodeError(err error) *ChaincodeError {
+	if chaincodeErr, ok := err.(*ChaincodeError); ok {
+		return chaincodeErr
+	}
+
+	if chaincodeErr, ok := ParseError(err.Error()); ok {
+		return chaincodeErr
+	}
+
+	return Internal(err.Error(), nil)
+}
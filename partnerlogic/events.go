@@ -0,0 +1,176 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// ReferralEventSchemaVersion is bumped whenever a field of ReferralEvents or ReferralEvent changes
+// meaning or is removed. Adding a field does not change the version
+const ReferralEventSchemaVersion = 2
+
+// EventReferralsChanged is the name of the one chaincode event set by a transaction that changed referrals
+const EventReferralsChanged = "referralsChanged"
+
+// The types of referral lifecycle changes
+const (
+	EventReferralCreated = "referralCreated"
+	EventReferralStatusUpdated = "referralStatusUpdated"
+	EventMortgageUpdated = "referralMortgageUpdated"
+	EventReferralClosed = "referralClosed"
+)
+
+// ReferralEvents is the payload of the chaincode event: every referral change a transaction made, in the
+// order they were made. A peer keeps a single event per transaction, so one transaction changing several
+// referrals, or the bank's referral and the partners' copies, lists them all here
+type ReferralEvents struct {
+	SchemaVersion int `json:"schemaVersion"`
+	TxId string `json:"txId"`
+	Timestamp int64 `json:"timestamp"`
+	Events []ReferralEvent `json:"events"`
+}
+
+// ReferralEvent is one change to one referral. Changes to a partner's copy set Partner to the partner's
+// name, changes to the bank's referral set Departments to the departments the referral is for
+type ReferralEvent struct {
+	EventType string `json:"eventType"`
+	ReferralId string `json:"referralId"`
+	OldStatus string `json:"oldStatus"`
+	NewStatus string `json:"newStatus"`
+	Partner string `json:"partner,omitempty"`
+	Departments []string `json:"departments,omitempty"`
+	Compensation *int64 `json:"compensation"`
+}
+
+// transactionStub is the stub invoke functions run with. It collects the referral changes of the
+// transaction, which Dispatch publishes as the transaction's chaincode event, and the moves between
+// status indexes, which Dispatch writes, once the function has succeeded
+type transactionStub struct {
+	shim.ChaincodeStubInterface
+	events []ReferralEvent
+	statusIndexes map[string]*indexChanges
+}
+
+// publish sets the collected changes as the transaction's chaincode event. A partner chaincode called by
+// the bank chaincode publishes nothing: the peer keeps only the event of the chaincode the transaction
+// was submitted to, and the bank lists the changes to the partners' copies in its own event
+func (s *transactionStub) publish() error {
+	if len(s.events) == 0 {
+		return nil
+	}
+
+	called, err := calledByBank(s)
+	if err != nil {
+		return err
+	}
+
+	if called {
+		return nil
+	}
+
+	timestamp, err := txTimeSeconds(s)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(ReferralEvents{
+		SchemaVersion: ReferralEventSchemaVersion,
+		TxId: s.GetTxID(),
+		Timestamp: timestamp,
+		Events: s.events,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.ChaincodeStubInterface.SetEvent(EventReferralsChanged, payload)
+	if err != nil {
+		return Internal("Failed to emit event " + EventReferralsChanged, err)
+	}
+
+	return nil
+}
+
+// EmitReferralEvent stamps the change with its type and adds it to the transaction's chaincode event
+func EmitReferralEvent(eventType string, event ReferralEvent, stub shim.ChaincodeStubInterface) error {
+	collector, ok := stub.(*transactionStub)
+	if !ok {
+		return Internal("Referral events can only be emitted by invoke functions run by Dispatch", nil)
+	}
+
+	event.EventType = eventType
+	collector.events = append(collector.events, event)
+	return nil
+}
+
+// EmitPartnerCopyEvent adds the change a partner chaincode made to its copy of a referral while the bank
+// called it, taken from the history of the copy the partner returned. The change is the last entry of
+// the history when this transaction recorded it; nothing is added otherwise
+func EmitPartnerCopyEvent(eventType string, partnerName string, partnerReferralId string, history []StatusChange, compensation *int64, stub shim.ChaincodeStubInterface) error {
+	if len(history) == 0 || history[len(history) - 1].TxId != stub.GetTxID() {
+		return nil
+	}
+
+	oldStatus := ""
+	if len(history) > 1 {
+		oldStatus = history[len(history) - 2].Status
+	}
+
+	return EmitReferralEvent(eventType, ReferralEvent{
+		ReferralId: partnerReferralId,
+		OldStatus: oldStatus,
+		NewStatus: history[len(history) - 1].Status,
+		Partner: partnerName,
+		Compensation: compensation,
+	}, stub)
+}
+
+// RecordStatusChange appends the status the referral just entered to its history
+func RecordStatusChange(history []StatusChange, status string, stub shim.ChaincodeStubInterface) ([]StatusChange, error) {
+	timestamp, err := txTimeSeconds(stub)
+	if err != nil {
+		return history, err
+	}
+
+	return append(history, StatusChange{Status: status, Timestamp: timestamp, TxId: stub.GetTxID()}), nil
+}
+
+// EmitPartnerReferralEvent emits a lifecycle event for a partner chaincode referral
+func EmitPartnerReferralEvent(eventType string, referralId string, oldStatus string, referral PartnerReferral, stub shim.ChaincodeStubInterface) error {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return err
+	}
+
+	return EmitReferralEvent(eventType, ReferralEvent{
+		ReferralId: referralId,
+		OldStatus: oldStatus,
+		NewStatus: referral.Status,
+		Partner: config.PartnerName,
+		Compensation: referral.Compensation,
+	}, stub)
+}
+
+// EmitCustomerReferralEvent emits a lifecycle event for a bank referral
+func EmitCustomerReferralEvent(eventType string, referralId string, oldStatus string, referral CustomerReferral, stub shim.ChaincodeStubInterface) error {
+	return EmitReferralEvent(eventType, ReferralEvent{
+		ReferralId: referralId,
+		OldStatus: oldStatus,
+		NewStatus: referral.Status,
+		Departments: referral.Departments,
+	}, stub)
+}
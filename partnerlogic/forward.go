@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// ForwardReferral creates the referral on the chaincode of each partner serving one of its departments,
+// recording the id the partner stored it under in referral.PartnerReferralIds and the status it started
+// in under referral.PartnerStatuses. Partners registered without a chaincode are skipped, and a partner
+// the customer has not consented to is refused. The copies created are added to the transaction's event
+func ForwardReferral(referralKey string, referral *CustomerReferral, stub shim.ChaincodeStubInterface) error {
+	for i := range referral.Departments {
+		partner, err := FindPartnerForDepartment(referral.Departments[i], stub)
+		if err != nil {
+			return err
+		}
+
+		if partner == nil || partner.ChaincodeName == "" {
+			continue
+		}
+
+		// A partner serving several of the referral's departments only gets the referral once
+		if _, forwarded := referral.PartnerReferralIds[partner.PartnerName]; forwarded {
+			continue
+		}
+
+		err = RequireConsent(referralKey, *referral, partner.PartnerName)
+		if err != nil {
+			return err
+		}
+
+		created, err := forwardToPartner(referralKey, *referral, *partner, stub)
+		if err != nil {
+			return err
+		}
+
+		if referral.PartnerReferralIds == nil {
+			referral.PartnerReferralIds = map[string]string{}
+		}
+		if referral.PartnerStatuses == nil {
+			referral.PartnerStatuses = map[string]string{}
+		}
+		referral.PartnerReferralIds[partner.PartnerName] = created.ReferralId
+		referral.PartnerStatuses[partner.PartnerName] = created.Status
+
+		err = EmitPartnerCopyEvent(EventReferralCreated, partner.PartnerName, created.ReferralId, created.StatusHistory, created.Compensation, stub)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Creates the referral on the partner's chaincode and returns the referral the partner stored
+func forwardToPartner(referralKey string, referral CustomerReferral, partner Partner, stub shim.ChaincodeStubInterface) (PartnerReferral, error) {
+	var created PartnerReferral
+
+	adapter := AdapterFor(partner.PartnerName)
+	if adapter == nil {
+		return created, Internal("No adapter is registered for partner " + partner.PartnerName, nil)
+	}
+
+	partnerReferral, err := adapter.MapFields(referral)
+	if err != nil {
+		return created, err
+	}
+
+	partnerReferral.ReferralId = referralKey
+
+	valAsbytes, err := json.Marshal(partnerReferral)
+	if err != nil {
+		return created, err
+	}
+
+	fmt.Println("Forwarding referral " + referralKey + " to " + partner.ChaincodeName)
+	response, err := CallChaincode(partner.ChaincodeName, "createReferral", []string{referralKey, string(valAsbytes)}, stub)
+	if err != nil {
+		return created, Internal("Failed to forward referral " + referralKey + " to partner " + partner.PartnerName, err)
+	}
+
+	// Partners answer with the referral they stored; fall back to the key and status we asked for
+	if json.Unmarshal(response, &created) != nil || created.ReferralId == "" {
+		created.ReferralId = referralKey
+	}
+	if created.Status == "" {
+		created.Status = InitialStatus
+	}
+
+	return created, nil
+}
\ No newline at end of file
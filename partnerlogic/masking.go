@@ -0,0 +1,250 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The ways a field of a query result can be masked. Only text is masked: a field holding anything else,
+// such as a number, is hidden whatever its mask
+const (
+	MaskInitials = "INITIALS"
+	MaskLastFour = "LAST_FOUR"
+	MaskHidden = "HIDDEN"
+)
+
+var masks = []string{MaskInitials, MaskLastFour, MaskHidden}
+
+// MaskingRules gives, for each role, the mask applied to a field of the referrals query functions return.
+// Fields are named by their JSON name. Roles without rules see the referrals unmasked
+type MaskingRules map[string]map[string]string
+
+// DefaultMaskingRules apply to the roles the chaincode configuration has no rules for. Auditors see who a
+// referral is for without the customer's details, and analysts, who only count referrals, do not see the
+// contact number at all
+var DefaultMaskingRules = MaskingRules{
+	RoleAuditor: {"customerName": MaskInitials, "contactNumber": MaskLastFour},
+	RoleAnalyst: {"customerName": MaskInitials, "contactNumber": MaskHidden},
+}
+
+func (rules MaskingRules) validate() error {
+	for role, fields := range rules {
+		for field, mask := range fields {
+			err := ValidateEnum("masking." + role + "." + field, mask, masks)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// MaskedQuery wraps a query function so its result is masked for the caller's role
+func MaskedQuery(query ChaincodeFunction) ChaincodeFunction {
+	return func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+		payload, err := query(stub, args)
+		if err != nil {
+			return nil, err
+		}
+
+		return MaskResult(payload, stub)
+	}
+}
+
+// MaskResult applies the masking rules of the caller's role to a query result holding a referral or a
+// list of referrals. Only the masked fields are rewritten: every other field keeps its place and its
+// bytes, so results that are hashed, such as statements, read the same for every role. Other results
+// are returned unchanged
+func MaskResult(payload []byte, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	var referrals []json.RawMessage
+
+	fields, err := callerMasks(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 {
+		return payload, nil
+	}
+
+	if json.Unmarshal(payload, &referrals) == nil {
+		masked := false
+		for i := range referrals {
+			referral, changed := maskFields(referrals[i], fields)
+			if changed {
+				referrals[i] = referral
+				masked = true
+			}
+		}
+
+		if !masked {
+			return payload, nil
+		}
+
+		result := []byte("[")
+		for i := range referrals {
+			if i > 0 {
+				result = append(result, ',')
+			}
+			result = append(result, referrals[i]...)
+		}
+
+		return append(result, ']'), nil
+	}
+
+	referral, changed := maskFields(payload, fields)
+	if changed {
+		return referral, nil
+	}
+
+	return payload, nil
+}
+
+// Returns the masks applied to the fields of the referrals the caller reads
+func callerMasks(stub shim.ChaincodeStubInterface) (map[string]string, error) {
+	caller, err := GetCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, configured := config.Masking[caller.Role]
+	if !configured {
+		fields = DefaultMaskingRules[caller.Role]
+	}
+
+	return fields, nil
+}
+
+// Masks the fields of a JSON object, copying the others through as they are. Returns false when the
+// value is not an object or none of its fields were masked
+func maskFields(referral []byte, fields map[string]string) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(referral))
+
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return nil, false
+	}
+
+	masked := []byte("{")
+	changed := false
+
+	for decoder.More() {
+		var raw json.RawMessage
+		var value interface{}
+
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, false
+		}
+
+		field, _ := token.(string)
+		err = decoder.Decode(&raw)
+		if err != nil {
+			return nil, false
+		}
+
+		mask, found := fields[field]
+		if found && json.Unmarshal(raw, &value) == nil && value != RedactedMarker {
+			changed = true
+
+			maskedValue, keep := maskValue(value, mask)
+			if !keep {
+				continue
+			}
+
+			raw, err = json.Marshal(maskedValue)
+			if err != nil {
+				return nil, false
+			}
+		}
+
+		name, err := json.Marshal(field)
+		if err != nil {
+			return nil, false
+		}
+
+		if len(masked) > 1 {
+			masked = append(masked, ',')
+		}
+		masked = append(append(append(masked, name...), ':'), raw...)
+	}
+
+	return append(masked, '}'), changed
+}
+
+// Returns the masked value, or false if the field should be left out
+func maskValue(value interface{}, mask string) (interface{}, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+
+	if mask == MaskInitials {
+		return initials(text), true
+	} else if mask == MaskLastFour {
+		return lastFour(text), true
+	}
+
+	return nil, false
+}
+
+// Reduces a name such as "Ann Marie Lee" to "A. M. L."
+func initials(name string) string {
+	var letters []string
+
+	for _, word := range strings.Fields(name) {
+		for _, r := range word {
+			if unicode.IsLetter(r) {
+				letters = append(letters, string(unicode.ToUpper(r)) + ".")
+				break
+			}
+		}
+	}
+
+	return strings.Join(letters, " ")
+}
+
+// Replaces every digit but the last four with *, so "(555) 123-4567" becomes "(***) ***-4567"
+func lastFour(number string) string {
+	digits := 0
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return r
+		}
+
+		digits--
+		if digits >= 4 {
+			return '*'
+		}
+		return r
+	}, number)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MortgageAdapter implements the bank's own mortgage desk as a referral partner
+type MortgageAdapter struct {
+}
+
+// The referral fee on a funded mortgage, in basis points of the loan amount
+const mortgageReferralBasisPoints = 10
+
+// The largest loan amount accepted, in whole currency units
+const maxMortgageAmount = 1000000000000
+
+func (a MortgageAdapter) PartnerName() string {
+	return "Mortgage"
+}
+
+func (a MortgageAdapter) ValidateReferral(referral PartnerReferral) error {
+	err := ValidatePartnerName(referral, a.PartnerName())
+	if err != nil {
+		return err
+	}
+
+	if referral.Mortgage != nil && referral.Mortgage.Amount != "" {
+		_, err = parseMortgageAmount(referral.Mortgage.Amount)
+	}
+
+	return err
+}
+
+// ComputeCompensation pays a fee on the funded amount, passed as the deal criteria. When no amount is
+// given the amount on the referral's mortgage data is used
+func (a MortgageAdapter) ComputeCompensation(referral PartnerReferral, dealCriteria string) (int64, error) {
+	fundedAmount := dealCriteria
+
+	if fundedAmount == "" && referral.Mortgage != nil {
+		fundedAmount = referral.Mortgage.Amount
+	}
+
+	amount, err := parseMortgageAmount(fundedAmount)
+	if err != nil {
+		return 0, err
+	}
+
+	// Split the amount so the multiplication cannot overflow
+	return amount / 10000 * mortgageReferralBasisPoints + amount % 10000 * mortgageReferralBasisPoints / 10000, nil
+}
+
+func (a MortgageAdapter) MapFields(referral CustomerReferral) (PartnerReferral, error) {
+	partnerReferral, err := MapBankReferral(referral, a.PartnerName())
+	if err != nil {
+		return partnerReferral, err
+	}
+
+	partnerReferral.Mortgage = referral.Mortgage
+	return partnerReferral, nil
+}
+
+// parseMortgageAmount reads a loan amount written as whole units with up to two decimals, such as
+// "250000" or "250000.50", and returns the whole units. Exponents, signs, NaN and Inf are rejected
+func parseMortgageAmount(amount string) (int64, error) {
+	invalid := InvalidArgument("amount", "Invalid mortgage amount " + amount)
+
+	units, cents := amount, ""
+	if dot := strings.IndexByte(amount, '.'); dot >= 0 {
+		units, cents = amount[:dot], amount[dot + 1:]
+		if cents == "" || len(cents) > 2 || !isDigits(cents) {
+			return 0, invalid
+		}
+	}
+
+	if units == "" || len(units) > 13 || !isDigits(units) {
+		return 0, invalid
+	}
+
+	value, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || value > maxMortgageAmount {
+		return 0, invalid
+	}
+
+	return value, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
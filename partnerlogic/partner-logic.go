@@ -1,170 +1,320 @@
-/*
-Copyright IBM Corp 2016 All Rights Reserved.
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-		 http://www.apache.org/licenses/LICENSE-2.0
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-package partnerlogic
-
-import (
-	"errors"
-	"reflect"
-	"unsafe"
-	"strings"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-)
-
-func BytesToString(b []byte) string {
-    bh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
-    sh := reflect.StringHeader{bh.Data, bh.Len}
-    return *(*string)(unsafe.Pointer(&sh))
-}
-
-func RemoveStatusReferralIndex(referralId string, status string, stub *shim.ChaincodeStub) (error) {
-	valAsbytes, err := stub.GetState(status)
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + status + "\"}"
-		return errors.New(jsonResp)
-	}
-	
-	if valAsbytes == nil {
-		return nil;
-	} else {
-		// Remove the referral from this status type, if it exists
-		commaDelimitedStatuses := BytesToString(valAsbytes)
-		referralIdsInCurrentStatus := strings.Split(commaDelimitedStatuses, ",")
-		updatedReferralIdList := ""
-		
-		appendComma := false
-		for i := range referralIdsInCurrentStatus {
-			if referralIdsInCurrentStatus[i] != referralId {
-			    if appendComma == false {
-					updatedReferralIdList += referralIdsInCurrentStatus[i]
-					appendComma = true
-				} else {
-					updatedReferralIdList = updatedReferralIdList + "," + referralIdsInCurrentStatus[i]
-				}
-			}
-		}
-		
-		err = stub.PutState(status, []byte(updatedReferralIdList))
-	}
-	
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to update state for " + status + "\"}"
-		return errors.New(jsonResp)
-	}
-	
-	return nil
-}
-
-// Adds the referral id to a ledger list item for the given department allowing for quick search of referrals in a given department
-func IndexByStatus(referralId string, status string, stub *shim.ChaincodeStub) (error) {
-	valAsbytes, err := stub.GetState(status)
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + status + "\"}"
-		return errors.New(jsonResp)
-	}
-	
-	if valAsbytes == nil {
-		err = stub.PutState(status, []byte(referralId))
-	} else {
-	    commaDelimitedStatuses := BytesToString(valAsbytes)
-		err = stub.PutState(status, []byte(commaDelimitedStatuses + "," + referralId))
-	}
-	
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to update state for " + status + "\"}"
-		return errors.New(jsonResp)
-	}
-	
-	return nil
-}
-
-func ProcessCommaDelimitedReferrals(delimitedReferrals string, stub *shim.ChaincodeStub) ([]byte, error) {
-	commaDelimitedReferrals := strings.Split(delimitedReferrals, ",")
-
-	referralResultSet := "["
-	
-	for i := range commaDelimitedReferrals {
-		valAsbytes, err := stub.GetState(commaDelimitedReferrals[i])
-		
-		if err != nil {
-			return nil, err
-		}
-		
-		if i == 0 {
-			referralResultSet = referralResultSet + BytesToString(valAsbytes)
-		} else {
-			referralResultSet = referralResultSet + "," + BytesToString(valAsbytes)
-		}
-	}
-	
-	referralResultSet += "]"
-	return []byte(referralResultSet), nil
-}
-
-func FindAllReferrals(stub *shim.ChaincodeStub, partnerName string) ([]byte, error) {
-	valAsbytes, err := stub.GetState(partnerName)
-	
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + partnerName + "\"}"
-		return nil, errors.New(jsonResp)
-	}
-	
-	valAsbytes, err = ProcessCommaDelimitedReferrals(BytesToString(valAsbytes), stub)
-	
-	if(err != nil) {
-		return nil, err
-	}
-	
-	return valAsbytes, nil
-}
-
-func SearchByStatus(status string, stub *shim.ChaincodeStub) ([]byte, error) {
-	valAsbytes, err := stub.GetState(status)
-	
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + status + "\"}"
-		return nil, errors.New(jsonResp)
-	}
-	
-	valAsbytes, err = ProcessCommaDelimitedReferrals(BytesToString(valAsbytes), stub)
-	
-	if(err != nil) {
-		return nil, err
-	}
-	
-	return valAsbytes, nil
-}
-
-
-// read - query function to read key/value pair
-func Read(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	var key, jsonResp string
-	var err error
-	
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the key to query")
-	}
-
-	key = args[0]
-	valAsbytes, err := stub.GetState(key)
-	
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + key + "\"}"
-		return []byte(jsonResp), err
-	}
-	
-	if valAsbytes == nil {
-		return []byte("Did not find entry for key: " + key), nil
-	}
-	return valAsbytes, nil
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"unsafe"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+func BytesToString(b []byte) string {
+    if b == nil {
+	    return ""
+	}
+	
+    bh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+    sh := reflect.StringHeader{bh.Data, bh.Len}
+    return *(*string)(unsafe.Pointer(&sh))
+}
+
+// Adds the referral id to a ledger list item for the given partner name
+func IndexByPartner(referralId string, partnerName string, stub shim.ChaincodeStubInterface) (error) {
+	valAsbytes, err := stub.GetState(partnerName)
+	if err != nil {
+		return Internal("Failed to get state for " + partnerName, err)
+	}
+	
+	if valAsbytes == nil {
+		err = stub.PutState(partnerName, []byte(referralId))
+	} else {
+	    commaDelimitedStatuses := BytesToString(valAsbytes)
+		err = stub.PutState(partnerName, []byte(commaDelimitedStatuses + "," + referralId))
+	}
+	
+	return err
+}
+
+// Removes the referral id from the index of the given status
+func RemoveStatusReferralIndex(referralId string, status string, stub shim.ChaincodeStubInterface) (error) {
+	return changeStatusIndex(referralId, status, false, stub)
+}
+
+// Moves the referral from the index of its old status to the index of its new status
+func ReindexByStatus(referralId string, oldStatus string, newStatus string, stub shim.ChaincodeStubInterface) (error) {
+	if oldStatus == newStatus {
+		return nil
+	}
+	
+	err := IndexByStatus(referralId, newStatus, stub)
+	if err != nil {
+		return err
+	}
+	
+	return RemoveStatusReferralIndex(referralId, oldStatus, stub)
+}
+
+// Adds the referral id to a ledger list item for the given status allowing for quick search of referrals in a given status
+func IndexByStatus(referralId string, status string, stub shim.ChaincodeStubInterface) (error) {
+	return changeStatusIndex(referralId, status, true, stub)
+}
+
+// indexChanges are the referral ids a transaction adds to and removes from one status index
+type indexChanges struct {
+	added []string
+	removed map[string]bool
+}
+
+func (c *indexChanges) record(referralId string, add bool) {
+	var added []string
+	
+	for _, id := range c.added {
+		if id != referralId {
+			added = append(added, id)
+		}
+	}
+	
+	if add {
+		delete(c.removed, referralId)
+		added = append(added, referralId)
+	} else {
+		c.removed[referralId] = true
+	}
+	
+	c.added = added
+}
+
+// changeStatusIndex adds a referral to or removes it from a status index. Reads do not see the writes
+// of their own transaction, so an invoke function run by Dispatch only records the change, and Dispatch
+// writes each index once with every change the function made to it. Anywhere else the change is
+// written straight away
+func changeStatusIndex(referralId string, status string, add bool, stub shim.ChaincodeStubInterface) (error) {
+	transaction, collecting := stub.(*transactionStub)
+	if !collecting {
+		changes := &indexChanges{removed: map[string]bool{}}
+		changes.record(referralId, add)
+		return writeStatusIndex(status, changes, stub)
+	}
+	
+	if transaction.statusIndexes == nil {
+		transaction.statusIndexes = map[string]*indexChanges{}
+	}
+	
+	changes, found := transaction.statusIndexes[status]
+	if !found {
+		changes = &indexChanges{removed: map[string]bool{}}
+		transaction.statusIndexes[status] = changes
+	}
+	
+	changes.record(referralId, add)
+	return nil
+}
+
+// writeStatusIndexes writes the status indexes the transaction changed, in key order
+func (s *transactionStub) writeStatusIndexes() error {
+	var statuses []string
+	
+	for status := range s.statusIndexes {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	
+	for _, status := range statuses {
+		err := writeStatusIndex(status, s.statusIndexes[status], s.ChaincodeStubInterface)
+		if err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// writeStatusIndex applies the changes to the committed index of a status, keeping each id once
+func writeStatusIndex(status string, changes *indexChanges, stub shim.ChaincodeStubInterface) (error) {
+	var referralIds []string
+	
+	valAsbytes, err := stub.GetState(status)
+	if err != nil {
+		return Internal("Failed to get state for " + status, err)
+	}
+	
+	indexed := map[string]bool{}
+	for _, referralId := range append(SplitReferralIds(BytesToString(valAsbytes)), changes.added...) {
+		if !indexed[referralId] && !changes.removed[referralId] {
+			indexed[referralId] = true
+			referralIds = append(referralIds, referralId)
+		}
+	}
+	
+	if valAsbytes == nil && len(referralIds) == 0 {
+		return nil
+	}
+	
+	err = stub.PutState(status, []byte(strings.Join(referralIds, ",")))
+	if err != nil {
+		return Internal("Failed to update state for " + status, err)
+	}
+	
+	return nil
+}
+
+// Splits a comma delimited index into its referral ids, dropping the empty entries left behind when
+// every referral has been removed from an index and new ones appended
+func SplitReferralIds(delimitedReferrals string) []string {
+	var referralIds []string
+	
+	for _, referralId := range strings.Split(delimitedReferrals, ",") {
+		if referralId != "" {
+			referralIds = append(referralIds, referralId)
+		}
+	}
+	
+	return referralIds
+}
+
+// ProcessCommaDelimitedReferrals returns the referrals as a JSON array, with their PII sealed. Only
+// auditedRead reveals PII
+func ProcessCommaDelimitedReferrals(delimitedReferrals string, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	commaDelimitedReferrals := SplitReferralIds(delimitedReferrals)
+
+	referralResultSet := "["
+	
+	for i := range commaDelimitedReferrals {
+		valAsbytes, err := stub.GetState(commaDelimitedReferrals[i])
+		
+		if err != nil {
+			return nil, err
+		}
+		
+		if i == 0 {
+			referralResultSet = referralResultSet + BytesToString(valAsbytes)
+		} else {
+			referralResultSet = referralResultSet + "," + BytesToString(valAsbytes)
+		}
+	}
+	
+	referralResultSet += "]"
+	return []byte(referralResultSet), nil
+}
+
+func FindAllReferrals(stub shim.ChaincodeStubInterface, partnerName string) ([]byte, error) {
+	valAsbytes, err := stub.GetState(partnerName)
+	
+	if err != nil {
+		return nil, Internal("Failed to get state for " + partnerName, err)
+	}
+	
+	valAsbytes, err = ProcessCommaDelimitedReferrals(BytesToString(valAsbytes), stub)
+	
+	if(err != nil) {
+		return nil, err
+	}
+	
+	return valAsbytes, nil
+}
+
+func SearchByStatus(status string, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	valAsbytes, err := stub.GetState(status)
+	
+	if err != nil {
+		return nil, Internal("Failed to get state for " + status, err)
+	}
+	
+	valAsbytes, err = ProcessCommaDelimitedReferrals(BytesToString(valAsbytes), stub)
+	
+	if(err != nil) {
+		return nil, err
+	}
+	
+	return valAsbytes, nil
+}
+
+// searchByStatus - query function returning the referrals in the status given as the only argument
+func SearchByStatusQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("the status to search for")
+	}
+	
+	return SearchByStatus(args[0], stub)
+}
+
+// GetCustomerReferral loads a bank referral, failing with NOT_FOUND if there is none under the key
+func GetCustomerReferral(referralKey string, stub shim.ChaincodeStubInterface) (CustomerReferral, error) {
+	var referral CustomerReferral
+	
+	err := getReferral(referralKey, &referral, stub)
+	return referral, err
+}
+
+// GetPartnerReferral loads a partner referral, failing with NOT_FOUND if there is none under the key
+func GetPartnerReferral(referralKey string, stub shim.ChaincodeStubInterface) (PartnerReferral, error) {
+	var referral PartnerReferral
+	
+	err := getReferral(referralKey, &referral, stub)
+	return referral, err
+}
+
+func getReferral(referralKey string, referral interface{}, stub shim.ChaincodeStubInterface) error {
+	valAsbytes, err := stub.GetState(referralKey)
+	if err != nil {
+		return Internal("Failed to get state for " + referralKey, err)
+	}
+	
+	if valAsbytes == nil {
+		return NotFound("Did not find referral " + referralKey)
+	}
+	
+	err = json.Unmarshal(valAsbytes, referral)
+	if err != nil {
+		return Internal("Failed to read referral " + referralKey, err)
+	}
+	
+	return nil
+}
+
+// read - query function to read key/value pair. Referrals are returned with their PII sealed; reading it
+// goes through auditedRead. The audit log is read through readAuditLog and accessReport
+func Read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var key string
+	var err error
+	
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("name of the key to query")
+	}
+
+	key = args[0]
+	
+	// The configuration is read through getConfig, which leaves out its secrets
+	if key == configKey {
+		return GetConfig(stub, nil)
+	}
+	
+	if strings.HasPrefix(key, auditKeyPrefix) {
+		return nil, InvalidArgument("key", key + " is an audit entry")
+	}
+	
+	valAsbytes, err := stub.GetState(key)
+	
+	if err != nil {
+		return nil, Internal("Failed to get state for " + key, err)
+	}
+	
+	if valAsbytes == nil {
+		return nil, NotFound("Did not find entry for key: " + key)
+	}
+	return valAsbytes, nil
 }
\ No newline at end of file
@@ -0,0 +1,42 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+// PaycorAdapter implements the Paycor merchant services partner
+type PaycorAdapter struct {
+}
+
+// Commission paid by Paycor, indexed by deal size then customer size
+var paycorClosingCommission = [3][4]int64{
+	{250, 300, 350, 400},
+	{1000, 1250, 1500, 1750},
+	{2000, 2500, 3000, 3500},
+}
+
+func (a PaycorAdapter) PartnerName() string {
+	return "Paycor"
+}
+
+func (a PaycorAdapter) ValidateReferral(referral PartnerReferral) error {
+	return ValidatePartnerName(referral, a.PartnerName())
+}
+
+func (a PaycorAdapter) ComputeCompensation(referral PartnerReferral, dealCriteria string) (int64, error) {
+	return TieredCommission(paycorClosingCommission, dealCriteria, referral.CustomerSize), nil
+}
+
+func (a PaycorAdapter) MapFields(referral CustomerReferral) (PartnerReferral, error) {
+	return MapBankReferral(referral, a.PartnerName())
+}
@@ -0,0 +1,262 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The transient field carrying the PII key of a scope is this prefix followed by the scope, for example
+// piiKey.Vantiv. A scope is the partner name of the chaincode holding the referral, the bank included
+const PIIKeyTransientPrefix = "piiKey."
+
+// The transient field carrying the customer name and contact number of a new referral. The arguments of
+// a proposal are recorded in the block with the transaction, its transient data is not
+const PIITransientName = "referral.pii"
+
+// EncryptedPII holds the customer name and contact number of a referral, sealed with AES-256-GCM under
+// the key of Scope. Ciphertext is the base64 nonce followed by the sealed JSON of the fields. Every
+// endorsing peer must write the same bytes, so the nonce is derived from the key, the transaction and
+// the referral rather than drawn at random
+type EncryptedPII struct {
+	Scope string `json:"scope"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// PIIFields are the JSON names of the PII fields of both referral types
+var PIIFields = []string{"customerName", "contactNumber"}
+
+// The PII fields both referral types share, in their ledger JSON form
+type referralPII struct {
+	CustomerName string `json:"customerName"`
+	ContactNumber string `json:"contactNumber"`
+}
+
+// PIIKey finds the key of a scope, first in the transient data of the proposal and then, on a development
+// chaincode, in the chaincode's configuration. Keys are 32 bytes, raw or base64 encoded
+func PIIKey(scope string, stub shim.ChaincodeStubInterface) ([]byte, bool, error) {
+	encodedKey, err := TransientValue(PIIKeyTransientPrefix + scope, stub)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if encodedKey == nil {
+		config, err := LoadConfig(stub)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !config.Development || config.PartnerName != scope || config.PIIKey == "" {
+			return nil, false, nil
+		}
+
+		encodedKey = []byte(config.PIIKey)
+	}
+
+	if len(encodedKey) == 32 {
+		return encodedKey, true, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(encodedKey))
+	if err != nil || len(key) != 32 {
+		return nil, false, InvalidArgument(PIIKeyTransientPrefix + scope, "The PII key of " + scope + " must be 32 bytes")
+	}
+
+	return key, true, nil
+}
+
+// TransientValue returns a field of the proposal's transient data, or nil if it was not passed
+func TransientValue(name string, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, Internal("Failed to get the transient data", err)
+	}
+
+	return transient[name], nil
+}
+
+// ReadTransientPII fills in the customer name and contact number of a new referral from the transient
+// data of the proposal. PII in the referral passed as an argument would end up in the block, so it is
+// refused
+func ReadTransientPII(referral *CustomerReferral, stub shim.ChaincodeStubInterface) error {
+	if referral.CustomerName != "" || referral.ContactNumber != "" {
+		return InvalidArgument("customerName", "The customer name and contact number are passed in the " + PIITransientName + " transient field, not in the referral")
+	}
+
+	valAsbytes, err := TransientValue(PIITransientName, stub)
+	if err != nil {
+		return err
+	}
+
+	if valAsbytes == nil {
+		return InvalidArgument(PIITransientName, "The customer name and contact number must be passed in the " + PIITransientName + " transient field")
+	}
+
+	var fields referralPII
+	err = DecodeStrict(string(valAsbytes), &fields)
+	if err != nil {
+		return err
+	}
+
+	referral.CustomerName = fields.CustomerName
+	referral.ContactNumber = fields.ContactNumber
+
+	return nil
+}
+
+// SealCustomerReferral encrypts the PII of a bank referral under the bank's key and clears the plaintext
+func SealCustomerReferral(referralKey string, referral *CustomerReferral, stub shim.ChaincodeStubInterface) error {
+	sealed, err := sealPII(referralKey, referralPII{CustomerName: referral.CustomerName, ContactNumber: referral.ContactNumber}, stub)
+	if err != nil {
+		return err
+	}
+
+	referral.PII = sealed
+	referral.CustomerName = ""
+	referral.ContactNumber = ""
+
+	return nil
+}
+
+// SealPartnerReferral encrypts the PII of a partner referral under the partner's key and clears the plaintext
+func SealPartnerReferral(referralKey string, referral *PartnerReferral, stub shim.ChaincodeStubInterface) error {
+	sealed, err := sealPII(referralKey, referralPII{CustomerName: referral.CustomerName, ContactNumber: referral.ContactNumber}, stub)
+	if err != nil {
+		return err
+	}
+
+	referral.PII = sealed
+	referral.CustomerName = ""
+	referral.ContactNumber = ""
+
+	return nil
+}
+
+// The PII is sealed under the key of the chaincode's own partner. Writing PII without a key fails, so
+// no plaintext reaches the ledger
+func sealPII(referralKey string, fields referralPII, stub shim.ChaincodeStubInterface) (*EncryptedPII, error) {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	key, found, err := PIIKey(config.PartnerName, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, InvalidArgument(PIIKeyTransientPrefix + config.PartnerName, "No PII key for " + config.PartnerName + " was supplied")
+	}
+
+	plaintext, err := json.Marshal(fields)
+	if err != nil {
+		return nil, Internal("Failed to marshal the PII", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := piiNonce(key, stub.GetTxID(), referralKey, "pii", gcm.NonceSize())
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(config.PartnerName))
+
+	return &EncryptedPII{Scope: config.PartnerName, Ciphertext: base64.StdEncoding.EncodeToString(sealed)}, nil
+}
+
+// RevealPII decrypts the PII of a referral in its ledger JSON form when the caller supplied the key of
+// its scope. Referrals without PII, or whose key was not supplied, are returned unchanged
+func RevealPII(valAsbytes []byte, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	var sealed EncryptedPII
+
+	if json.Unmarshal(valAsbytes, &fields) != nil || fields["pii"] == nil {
+		return valAsbytes, nil
+	}
+
+	if json.Unmarshal(fields["pii"], &sealed) != nil {
+		return valAsbytes, nil
+	}
+
+	key, found, err := PIIKey(sealed.Scope, stub)
+	if err != nil || !found {
+		return valAsbytes, err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, Internal("Failed to decode the PII of a referral", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, Internal("The PII of a referral is truncated", nil)
+	}
+
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], []byte(sealed.Scope))
+	if err != nil {
+		return nil, Forbidden("The PII key of " + sealed.Scope + " does not decrypt the referral")
+	}
+
+	var piiFields map[string]json.RawMessage
+	err = json.Unmarshal(plaintext, &piiFields)
+	if err != nil {
+		return nil, Internal("Failed to read the PII of a referral", err)
+	}
+
+	for name, value := range piiFields {
+		fields[name] = value
+	}
+	delete(fields, "pii")
+
+	return json.Marshal(fields)
+}
+
+// piiNonce derives the GCM nonce as HMAC-SHA256(key, txId || referralKey || field), truncated to the
+// nonce size. A referral's PII is sealed once per transaction, so a nonce is never reused under a key
+func piiNonce(key []byte, txId string, referralKey string, field string, size int) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range []string{txId, referralKey, field} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+
+	return mac.Sum(nil)[:size]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, Internal("Failed to create the PII cipher", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, Internal("Failed to create the PII cipher", err)
+	}
+
+	return gcm, nil
+}
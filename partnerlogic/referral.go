@@ -0,0 +1,132 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package partnerlogic
+
+// The statuses a referral moves through, in the order readAllReferrals reports them
+var ReferralStatuses = []string{"ACTIVE", "DECLINED", "PENDING", "CLOSED"}
+
+// The statuses of a forwarded referral's partners rank in this order when the bank's status is derived
+// from them. One partner closing a deal closes the referral, any partner still working on it keeps it
+// open, and it is only declined once every partner has declined it
+var overallStatusPrecedence = []string{"CLOSED", "PENDING", "ACTIVE", "DECLINED"}
+
+// OverallStatus derives the status of a forwarded referral from the status of each partner it was
+// forwarded to. A partner that has not reported yet counts as InitialStatus. Referrals that were not
+// forwarded keep their own status
+func (referral CustomerReferral) OverallStatus() string {
+	if len(referral.PartnerReferralIds) == 0 {
+		return referral.Status
+	}
+
+	for _, status := range overallStatusPrecedence {
+		for partnerName := range referral.PartnerReferralIds {
+			partnerStatus, reported := referral.PartnerStatuses[partnerName]
+			if !reported {
+				partnerStatus = InitialStatus
+			}
+
+			if partnerStatus == status {
+				return status
+			}
+		}
+	}
+
+	return referral.Status
+}
+
+// IsOpenStatus reports whether a referral in the status can still be worked on
+func IsOpenStatus(status string) bool {
+	return status == "ACTIVE" || status == "PENDING"
+}
+
+// CustomerReferral is the referral recorded by the bank chaincode. BranchId is the branch of the referring
+// employee, when known. PartnerReferralIds holds the id each
+// partner chaincode the referral was forwarded to knows it by, keyed by partner name. PartnerStatuses,
+// PartnerHistory and PartnerCompensation are the status, status history and commission last read back
+// from each of those partners, and Status is derived from them by OverallStatus. Once
+// written, CustomerName and ContactNumber are only kept encrypted in PII. CustomerHash identifies the
+// customer without revealing them, and DuplicateOf names the first referral of a customer referred again.
+// Consent is the customer's permission to share the referral with partners. Redaction is set once the
+// customer's personal data has been erased
+type CustomerReferral struct {
+	ReferralId string `json:"referralId"`
+	CustomerName string `json:"customerName"`
+	ContactNumber string `json:"contactNumber"`
+	CustomerId string `json:"customerId"`
+	EmployeeId string `json:"employeeId"`
+	BranchId string `json:"branchId,omitempty"`
+	Departments []string `json:"departments"`
+	CreateDate int64 `json:"createDate"`
+	Status string `json:"status"`
+	Mortgage *Mortgage `json:"mortgage"`
+	PartnerReferralIds map[string]string `json:"partnerReferralIds,omitempty"`
+	PartnerStatuses map[string]string `json:"partnerStatuses,omitempty"`
+	PartnerHistory map[string][]StatusChange `json:"partnerHistory,omitempty"`
+	PartnerCompensation map[string]int64 `json:"partnerCompensation,omitempty"`
+	StatusHistory []StatusChange `json:"statusHistory,omitempty"`
+	PII *EncryptedPII `json:"pii,omitempty"`
+	CustomerHash string `json:"customerHash,omitempty"`
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+	Consent *Consent `json:"consent,omitempty"`
+	Redaction *Redaction `json:"redaction,omitempty"`
+}
+
+type Mortgage struct {
+	MortgageNumber string `json:"mortgageNumber"`
+	MortgageType string `json:"mortgageType"`
+	ReferralId string `json:"referralId"`
+	Rate string `json:"rate"`
+	Amount string `json:"amount"`
+}
+
+// PartnerReferral is the referral recorded by a partner chaincode. Once written, CustomerName and
+// ContactNumber are only kept encrypted in PII, and Redaction is set once they have been erased
+type PartnerReferral struct {
+	ReferralId string `json:"referralId"`
+	CustomerName string `json:"customerName"`
+	ContactNumber string `json:"contactNumber"`
+	CreateDate int64 `json:"createDate"`
+	Status string `json:"status"`
+	BranchId string `json:"branchId"`
+	CustomerSize string `json:"customerSize"`
+	Compensation *int64 `json:"compensation"`
+	PartnerName string `json:"partnerName"`
+	DealCriteria string `json:"dealCriteria"`
+	Mortgage *Mortgage `json:"mortgage,omitempty"`
+	BankReferralId string `json:"bankReferralId,omitempty"`
+	CustomerId string `json:"customerId,omitempty"`
+	StatusHistory []StatusChange `json:"statusHistory,omitempty"`
+	PII *EncryptedPII `json:"pii,omitempty"`
+	Redaction *Redaction `json:"redaction,omitempty"`
+}
+
+// StatusChange records a referral entering a status. Timestamp is the transaction time in seconds
+// since the epoch. A partner closing a deal also records the deal criteria and compensation it closed with
+type StatusChange struct {
+	Status string `json:"status"`
+	Timestamp int64 `json:"timestamp"`
+	TxId string `json:"txId"`
+	DealCriteria string `json:"dealCriteria,omitempty"`
+	Compensation *int64 `json:"compensation,omitempty"`
+}
+
+// RedactedMarker replaces the personal data of a redacted referral
+const RedactedMarker = "[REDACTED]"
+
+// Redaction records when and by whom a referral's personal data was erased
+type Redaction struct {
+	Timestamp int64 `json:"timestamp"`
+	TxId string `json:"txId"`
+	RedactedBy string `json:"redactedBy"`
+}
@@ -0,0 +1,322 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The states a registered partner can be in. Only ACTIVE partners may receive referrals
+const (
+	PartnerActive = "ACTIVE"
+	PartnerSuspended = "SUSPENDED"
+	PartnerTerminated = "TERMINATED"
+)
+
+// Ledger keys used by the registry. Partner names on their own already key the partner referral index
+const (
+	partnersKey = "PARTNERS"
+	partnerKeyPrefix = "PARTNER_"
+	departmentKeyPrefix = "DEPARTMENT_"
+)
+
+// Partner is the registry record for a referral partner. Referrals for partners with a ChaincodeName
+// are forwarded to that chaincode when the bank creates them
+type Partner struct {
+	PartnerName string `json:"partnerName"`
+	DisplayName string `json:"displayName"`
+	ChaincodeName string `json:"chaincodeName"`
+	Departments []string `json:"departments"`
+	ContractStartDate int64 `json:"contractStartDate"`
+	ContractEndDate int64 `json:"contractEndDate"`
+	State string `json:"state"`
+}
+
+// GetPartner loads a partner from the registry, returning nil if the partner was never onboarded
+func GetPartner(partnerName string, stub shim.ChaincodeStubInterface) (*Partner, error) {
+	var partner Partner
+
+	valAsbytes, err := stub.GetState(partnerKeyPrefix + partnerName)
+	if err != nil {
+		return nil, Internal("Failed to get state for partner " + partnerName, err)
+	}
+
+	if valAsbytes == nil {
+		return nil, nil
+	}
+
+	err = json.Unmarshal(valAsbytes, &partner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &partner, nil
+}
+
+func putPartner(partner Partner, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	valAsbytes, err := json.Marshal(partner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(partnerKeyPrefix + partner.PartnerName, valAsbytes)
+	if err != nil {
+		return nil, Internal("Failed to update state for partner " + partner.PartnerName, err)
+	}
+
+	return valAsbytes, nil
+}
+
+// FindPartnerForDepartment returns the partner serving a department, or nil if no partner serves it
+func FindPartnerForDepartment(department string, stub shim.ChaincodeStubInterface) (*Partner, error) {
+	valAsbytes, err := stub.GetState(departmentKeyPrefix + department)
+	if err != nil {
+		return nil, Internal("Failed to get state for department " + department, err)
+	}
+
+	if valAsbytes == nil {
+		return nil, nil
+	}
+
+	return GetPartner(BytesToString(valAsbytes), stub)
+}
+
+// IsPartnerActive reports whether a partner may receive referrals at the given time, in seconds since the epoch.
+// A contract end date of zero means the contract is open ended
+func IsPartnerActive(partner *Partner, now int64) bool {
+	if partner == nil || partner.State != PartnerActive {
+		return false
+	}
+
+	if partner.ContractStartDate != 0 && now < partner.ContractStartDate {
+		return false
+	}
+
+	if partner.ContractEndDate != 0 && now > partner.ContractEndDate {
+		return false
+	}
+
+	return true
+}
+
+// ValidateDepartments rejects a referral naming a department that no active partner serves
+func ValidateDepartments(departments []string, stub shim.ChaincodeStubInterface) error {
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return err
+	}
+
+	for i := range departments {
+		partner, err := FindPartnerForDepartment(departments[i], stub)
+		if err != nil {
+			return err
+		}
+
+		if partner == nil {
+			return InvalidArgument("departments", "No partner serves department " + departments[i])
+		}
+
+		if !IsPartnerActive(partner, now) {
+			return Conflict("Partner " + partner.PartnerName + " serving department " + departments[i] + " is not active")
+		}
+	}
+
+	return nil
+}
+
+func txTimeSeconds(stub shim.ChaincodeStubInterface) (int64, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, Internal("Failed to get the transaction timestamp", err)
+	}
+
+	return timestamp.Seconds, nil
+}
+
+// OnboardPartner - invoke function to add a partner to the registry, or to bring back a terminated one
+func OnboardPartner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var partner Partner
+	var err error
+
+	fmt.Println("running onboardPartner()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("1. the partner to onboard")
+	}
+
+	err = DecodeStrict(args[0], &partner)
+	if err != nil {
+		return nil, err
+	}
+
+	if partner.PartnerName == "" || len(partner.Departments) == 0 {
+		return nil, InvalidArgument("", "A partner needs a partnerName and at least one department")
+	}
+
+	if partner.ContractEndDate != 0 && partner.ContractEndDate < partner.ContractStartDate {
+		return nil, InvalidArgument("contractEndDate", "Contract for " + partner.PartnerName + " ends before it starts")
+	}
+
+	existing, err := GetPartner(partner.PartnerName, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil && existing.State != PartnerTerminated {
+		return nil, Conflict("Partner " + partner.PartnerName + " is already onboarded")
+	}
+
+	// A department can only be served by one partner at a time
+	for i := range partner.Departments {
+		current, err := FindPartnerForDepartment(partner.Departments[i], stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if current != nil && current.PartnerName != partner.PartnerName && current.State != PartnerTerminated {
+			return nil, Conflict("Department " + partner.Departments[i] + " is already served by " + current.PartnerName)
+		}
+	}
+
+	partner.State = PartnerActive
+
+	for i := range partner.Departments {
+		err = stub.PutState(departmentKeyPrefix + partner.Departments[i], []byte(partner.PartnerName))
+		if err != nil {
+			return nil, Internal("Failed to update state for department " + partner.Departments[i], err)
+		}
+	}
+
+	if existing == nil {
+		err = IndexByPartnerName(partner.PartnerName, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return putPartner(partner, stub)
+}
+
+// Adds the partner name to the ledger list of registered partners
+func IndexByPartnerName(partnerName string, stub shim.ChaincodeStubInterface) error {
+	valAsbytes, err := stub.GetState(partnersKey)
+	if err != nil {
+		return Internal("Failed to get state for " + partnersKey, err)
+	}
+
+	if len(valAsbytes) == 0 {
+		err = stub.PutState(partnersKey, []byte(partnerName))
+	} else {
+		err = stub.PutState(partnersKey, []byte(BytesToString(valAsbytes) + "," + partnerName))
+	}
+
+	return err
+}
+
+// UpdatePartnerState - invoke function to suspend, reinstate or terminate a partner
+func UpdatePartnerState(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running updatePartnerState()")
+
+	if len(args) != 2 {
+		return nil, WrongArgumentCount("2. the partner name and the new state")
+	}
+
+	return setPartnerState(args[0], args[1], stub)
+}
+
+// OffboardPartner - invoke function to terminate a partner. Its record and referrals stay on the ledger
+func OffboardPartner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running offboardPartner()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("1. the partner name")
+	}
+
+	return setPartnerState(args[0], PartnerTerminated, stub)
+}
+
+func setPartnerState(partnerName string, state string, stub shim.ChaincodeStubInterface) ([]byte, error) {
+	if state != PartnerActive && state != PartnerSuspended && state != PartnerTerminated {
+		return nil, InvalidArgument("state", "Unknown partner state " + state)
+	}
+
+	partner, err := GetPartner(partnerName, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if partner == nil {
+		return nil, NotFound("Unknown partner " + partnerName)
+	}
+
+	// Terminated partners have to be onboarded again so their departments are rechecked
+	if partner.State == PartnerTerminated && state != PartnerTerminated {
+		return nil, Conflict("Partner " + partnerName + " is terminated and must be onboarded again")
+	}
+
+	partner.State = state
+	return putPartner(*partner, stub)
+}
+
+// ReadPartner - query function to read a partner from the registry
+func ReadPartner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("the partner name to query")
+	}
+
+	valAsbytes, err := stub.GetState(partnerKeyPrefix + args[0])
+	if err != nil {
+		return nil, Internal("Failed to get state for partner " + args[0], err)
+	}
+
+	if valAsbytes == nil {
+		return nil, NotFound("Unknown partner " + args[0])
+	}
+
+	return valAsbytes, nil
+}
+
+// ReadAllPartners - query function returning every partner in the registry as a JSON array
+func ReadAllPartners(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	var partners []Partner
+
+	valAsbytes, err := stub.GetState(partnersKey)
+	if err != nil {
+		return nil, Internal("Failed to get state for " + partnersKey, err)
+	}
+
+	partnerNames := BytesToString(valAsbytes)
+	if partnerNames != "" {
+		for _, partnerName := range strings.Split(partnerNames, ",") {
+			partner, err := GetPartner(partnerName, stub)
+			if err != nil {
+				return nil, err
+			}
+
+			if partner != nil {
+				partners = append(partners, *partner)
+			}
+		}
+	}
+
+	if partners == nil {
+		partners = []Partner{}
+	}
+
+	return json.Marshal(partners)
+}
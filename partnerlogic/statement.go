@@ -0,0 +1,323 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Recorded statement hashes are kept under this prefix followed by the period
+const statementKeyPrefix = "STATEMENT_"
+
+// Statement lists the deals a partner closed in a month and the commission it owes for them. A deal that
+// leaves CLOSED, or is closed again with other criteria, is reversed in the month that happens. Hash
+// covers every other field, so two parties holding statements with the same hash hold the same figures
+type Statement struct {
+	PartnerName string `json:"partnerName"`
+	Period string `json:"period"`
+	ClosedDeals []StatementLine `json:"closedDeals"`
+	Reversals []StatementLine `json:"reversals"`
+	Totals StatementTotals `json:"totals"`
+	Hash string `json:"hash,omitempty"`
+	Record *StatementRecord `json:"record,omitempty"`
+}
+
+// StatementLine is a deal closed or reversed. Timestamp is when that happened
+type StatementLine struct {
+	ReferralId string `json:"referralId"`
+	BankReferralId string `json:"bankReferralId,omitempty"`
+	BranchId string `json:"branchId,omitempty"`
+	Timestamp int64 `json:"timestamp"`
+	DealCriteria string `json:"dealCriteria"`
+	CustomerSize string `json:"customerSize"`
+	Compensation int64 `json:"compensation"`
+}
+
+type StatementTotals struct {
+	ClosedDeals int `json:"closedDeals"`
+	Compensation int64 `json:"compensation"`
+	Reversals int `json:"reversals"`
+	ReversedCompensation int64 `json:"reversedCompensation"`
+	NetCompensation int64 `json:"netCompensation"`
+}
+
+// The sides confirming a statement. Each confirms it once, from an MSP of its own
+const (
+	StatementSideBank = "BANK"
+	StatementSidePartner = "PARTNER"
+)
+
+// The side each role that may record a statement confirms it for
+var statementSides = map[string]string{RoleBankManager: StatementSideBank, RolePartnerAdmin: StatementSidePartner}
+
+// StatementRecord is the hash of a statement as recorded on the ledger, with everyone who confirmed it.
+// Confirmed is set once both the bank and the partner have
+type StatementRecord struct {
+	Hash string `json:"hash"`
+	Confirmations []StatementConfirmation `json:"confirmations"`
+	Confirmed bool `json:"confirmed"`
+}
+
+type StatementConfirmation struct {
+	ConfirmedBy string `json:"confirmedBy"`
+	Role string `json:"role"`
+	Side string `json:"side"`
+	MspId string `json:"mspId"`
+	Timestamp int64 `json:"timestamp"`
+	TxId string `json:"txId"`
+}
+
+// ParseMonth turns a YYYY-MM month into the first and last second it covers
+func ParseMonth(period string) (int64, int64, error) {
+	month, err := time.Parse("2006-01", period)
+	if err != nil {
+		return 0, 0, InvalidArgument("period", "Invalid period " + period + ", expecting YYYY-MM")
+	}
+
+	return month.Unix(), month.AddDate(0, 1, 0).Unix() - 1, nil
+}
+
+// BuildStatement works a partner's statement for a month out from the status history of its referrals.
+// Closing entries carry the deal criteria and compensation they were closed with; older ones fall back to
+// the referral's own
+func BuildStatement(partnerName string, period string, referrals []PartnerReferral) (Statement, error) {
+	statement := Statement{PartnerName: partnerName, Period: period, ClosedDeals: []StatementLine{}, Reversals: []StatementLine{}}
+
+	start, end, err := ParseMonth(period)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, referral := range referrals {
+		var closed *StatementLine
+
+		for _, change := range referral.StatusHistory {
+			inPeriod := change.Timestamp >= start && change.Timestamp <= end
+
+			if closed != nil && inPeriod {
+				reversal := *closed
+				reversal.Timestamp = change.Timestamp
+				statement.Reversals = append(statement.Reversals, reversal)
+			}
+			closed = nil
+
+			if change.Status != "CLOSED" {
+				continue
+			}
+
+			closed = &StatementLine{
+				ReferralId: referral.ReferralId,
+				BankReferralId: referral.BankReferralId,
+				BranchId: referral.BranchId,
+				Timestamp: change.Timestamp,
+				DealCriteria: change.DealCriteria,
+				CustomerSize: referral.CustomerSize,
+			}
+
+			if change.Compensation != nil {
+				closed.Compensation = *change.Compensation
+			} else {
+				closed.DealCriteria = referral.DealCriteria
+				if referral.Compensation != nil {
+					closed.Compensation = *referral.Compensation
+				}
+			}
+
+			if inPeriod {
+				statement.ClosedDeals = append(statement.ClosedDeals, *closed)
+			}
+		}
+	}
+
+	for _, lines := range [][]StatementLine{statement.ClosedDeals, statement.Reversals} {
+		sort.Slice(lines, func(i, j int) bool {
+			if lines[i].Timestamp != lines[j].Timestamp {
+				return lines[i].Timestamp < lines[j].Timestamp
+			}
+			return lines[i].ReferralId < lines[j].ReferralId
+		})
+	}
+
+	for _, line := range statement.ClosedDeals {
+		statement.Totals.ClosedDeals++
+		statement.Totals.Compensation += line.Compensation
+	}
+	for _, line := range statement.Reversals {
+		statement.Totals.Reversals++
+		statement.Totals.ReversedCompensation += line.Compensation
+	}
+	statement.Totals.NetCompensation = statement.Totals.Compensation - statement.Totals.ReversedCompensation
+
+	statement.Hash, err = statement.ComputeHash()
+	return statement, err
+}
+
+// ComputeHash returns the hex SHA-256 of the statement's JSON without its hash and record
+func (s Statement) ComputeHash() (string, error) {
+	s.Hash = ""
+	s.Record = nil
+
+	valAsbytes, err := json.Marshal(s)
+	if err != nil {
+		return "", Internal("Failed to marshal the statement", err)
+	}
+
+	sum := sha256.Sum256(valAsbytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Builds the statement of this partner chaincode for a period, along with its recorded hash if any
+func partnerStatement(period string, stub shim.ChaincodeStubInterface) (Statement, error) {
+	var referrals []PartnerReferral
+	var statement Statement
+
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return statement, err
+	}
+
+	for _, status := range ReferralStatuses {
+		var found []PartnerReferral
+
+		valAsbytes, err := SearchByStatus(status, stub)
+		if err != nil {
+			return statement, err
+		}
+
+		err = json.Unmarshal(valAsbytes, &found)
+		if err != nil {
+			return statement, Internal("Failed to read the " + status + " referrals", err)
+		}
+
+		referrals = append(referrals, found...)
+	}
+
+	statement, err = BuildStatement(config.PartnerName, period, referrals)
+	if err != nil {
+		return statement, err
+	}
+
+	valAsbytes, err := stub.GetState(statementKeyPrefix + period)
+	if err != nil {
+		return statement, Internal("Failed to get state for " + statementKeyPrefix + period, err)
+	}
+
+	if valAsbytes != nil {
+		statement.Record = &StatementRecord{}
+		err = json.Unmarshal(valAsbytes, statement.Record)
+		if err != nil {
+			return statement, Internal("Failed to read the statement record of " + period, err)
+		}
+	}
+
+	return statement, nil
+}
+
+// ReadStatement - query function returning the partner's statement for the month given as YYYY-MM. When
+// its hash was recorded the record is included, and a different hash means the figures have changed
+func ReadStatement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running readStatement()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("the period")
+	}
+
+	statement, err := partnerStatement(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(statement)
+}
+
+// RecordStatement - invoke function recording the hash of the partner's statement for a month that has
+// ended. The first call records it and the other side's call confirms it, so each side can show it agreed
+// to the same figures. A call whose statement no longer matches the recorded hash, or from a side or MSP
+// that already confirmed it, fails with CONFLICT
+func RecordStatement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("running recordStatement()")
+
+	if len(args) != 1 {
+		return nil, WrongArgumentCount("the period")
+	}
+
+	statement, err := partnerStatement(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := txTimeSeconds(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	_, end, _ := ParseMonth(args[0])
+	if timestamp <= end {
+		return nil, Conflict("The statement for " + args[0] + " cannot be recorded before the month ends")
+	}
+
+	if statement.Record == nil {
+		statement.Record = &StatementRecord{Hash: statement.Hash}
+	} else if statement.Record.Hash != statement.Hash {
+		return nil, Conflict("The statement for " + args[0] + " was recorded with hash " + statement.Record.Hash + " but now has hash " + statement.Hash)
+	}
+
+	caller, err := GetCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	side, found := statementSides[caller.Role]
+	if !found {
+		return nil, Forbidden("Role " + caller.Role + " cannot confirm statements")
+	}
+
+	for _, confirmation := range statement.Record.Confirmations {
+		if confirmation.Side == side {
+			return nil, Conflict("The statement for " + args[0] + " was already confirmed by the " + side + " side").WithDetail("confirmedBy", confirmation.ConfirmedBy)
+		}
+		if confirmation.MspId == caller.MspId {
+			return nil, Conflict("The statement for " + args[0] + " was already confirmed from MSP " + caller.MspId).WithDetail("confirmedBy", confirmation.ConfirmedBy)
+		}
+	}
+
+	statement.Record.Confirmations = append(statement.Record.Confirmations, StatementConfirmation{
+		ConfirmedBy: caller.Id,
+		Role: caller.Role,
+		Side: side,
+		MspId: caller.MspId,
+		Timestamp: timestamp,
+		TxId: stub.GetTxID(),
+	})
+	statement.Record.Confirmed = len(statement.Record.Confirmations) == len(statementSides)
+
+	valAsbytes, err := json.Marshal(statement.Record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(statementKeyPrefix + args[0], valAsbytes)
+	if err != nil {
+		return nil, Internal("Failed to update state for " + statementKeyPrefix + args[0], err)
+	}
+
+	return json.Marshal(statement)
+}
@@ -0,0 +1,412 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Status counts are kept under this prefix followed by the dimension, the day the referral was created,
+// the dimension value, the transaction and the referral, so a report reads the range of its dimension
+// and days only. Each transaction writes its own counts without reading anyone else's, so concurrent
+// transactions never conflict over a shared counter
+const statsKeyPrefix = "STATS_"
+
+// The dimensions referral statistics are reported by. Every referral is counted under DimensionAll with
+// an empty value. Bank referrals are counted by employee, branch and the partners of their departments,
+// partner referrals by their partner and branch
+const (
+	DimensionAll = "all"
+	DimensionPartner = "partner"
+	DimensionEmployee = "employee"
+	DimensionBranch = "branch"
+)
+
+var StatDimensions = []string{DimensionAll, DimensionPartner, DimensionEmployee, DimensionBranch}
+
+// StatCreated counts the referrals created and StatAccepted the referrals accepted, which they are when
+// they first move to AcceptedStatus or close. The other counts are of referrals that ever entered each
+// status: a referral entering a status again, such as a deal closed again after being reopened, is not
+// counted twice
+const StatCreated = "CREATED"
+const StatAccepted = "ACCEPTED"
+const AcceptedStatus = "PENDING"
+
+// StatExpired counts the referrals still open ExpiryDays after they were created, at the time of the
+// report. DefaultExpiryDays applies when the chaincode configuration sets no expiryDays
+const StatExpired = "EXPIRED"
+const DefaultExpiryDays = 90
+
+// StatKey names the counter a referral is counted under for one dimension
+type StatKey struct {
+	Dimension string
+	Value string
+}
+
+// StatCounter holds the counts one transaction added to one dimension value for the referrals created on
+// a day
+type StatCounter struct {
+	Day string `json:"day"`
+	Dimension string `json:"dimension"`
+	Value string `json:"value"`
+	Counts map[string]int64 `json:"counts"`
+}
+
+// FunnelStats are the counts of one dimension value for the referrals created over a date range and the
+// conversion rates from them. Every count is of the same referrals, so the rates are fractions of the
+// referrals created, except AcceptedCloseRate which is the fraction of accepted referrals that closed.
+// ExpiryRate is the fraction still open past the report's expiry age
+type FunnelStats struct {
+	Value string `json:"value"`
+	Counts map[string]int64 `json:"counts"`
+	AcceptanceRate float64 `json:"acceptanceRate"`
+	CloseRate float64 `json:"closeRate"`
+	AcceptedCloseRate float64 `json:"acceptedCloseRate"`
+	DeclineRate float64 `json:"declineRate"`
+	ExpiryRate float64 `json:"expiryRate"`
+}
+
+// StatsReport is the result of referralStats. From and To are inclusive days, empty when unbounded.
+// ExpiryDays is the age past which open referrals were counted as expired
+type StatsReport struct {
+	Dimension string `json:"dimension"`
+	From string `json:"from,omitempty"`
+	To string `json:"to,omitempty"`
+	ExpiryDays int `json:"expiryDays"`
+	Groups []FunnelStats `json:"groups"`
+}
+
+// CountCustomerReferral counts a bank referral that was created, when oldStatus is empty, or moved from
+// oldStatus to its current status. The change must already be recorded in the referral's status history
+func CountCustomerReferral(referralKey string, referral CustomerReferral, oldStatus string, stub shim.ChaincodeStubInterface) error {
+	keys, err := customerReferralStatKeys(referral, stub)
+	if err != nil {
+		return err
+	}
+
+	return countStatusChange(referralKey, keys, referral.StatusHistory, oldStatus, referral.Status, stub)
+}
+
+// CountPartnerReferral counts a partner referral that was created, when oldStatus is empty, or moved
+// from oldStatus to its current status. The change must already be recorded in the referral's status
+// history
+func CountPartnerReferral(referralKey string, referral PartnerReferral, oldStatus string, stub shim.ChaincodeStubInterface) error {
+	keys, err := partnerReferralStatKeys(referral, stub)
+	if err != nil {
+		return err
+	}
+
+	return countStatusChange(referralKey, keys, referral.StatusHistory, oldStatus, referral.Status, stub)
+}
+
+// Returns the counters a bank referral is counted under
+func customerReferralStatKeys(referral CustomerReferral, stub shim.ChaincodeStubInterface) ([]StatKey, error) {
+	keys := []StatKey{{DimensionAll, ""}, {DimensionEmployee, referral.EmployeeId}}
+	if referral.BranchId != "" {
+		keys = append(keys, StatKey{DimensionBranch, referral.BranchId})
+	}
+
+	for i := range referral.Departments {
+		partner, err := FindPartnerForDepartment(referral.Departments[i], stub)
+		if err != nil {
+			return nil, err
+		}
+
+		if partner != nil {
+			keys = appendStatKey(keys, StatKey{DimensionPartner, partner.PartnerName})
+		}
+	}
+
+	return keys, nil
+}
+
+// Returns the counters a partner referral is counted under
+func partnerReferralStatKeys(referral PartnerReferral, stub shim.ChaincodeStubInterface) ([]StatKey, error) {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []StatKey{{DimensionAll, ""}, {DimensionPartner, config.PartnerName}}
+	if referral.BranchId != "" {
+		keys = append(keys, StatKey{DimensionBranch, referral.BranchId})
+	}
+
+	return keys, nil
+}
+
+// A partner serving several of the referral's departments counts the referral once
+func appendStatKey(keys []StatKey, key StatKey) []StatKey {
+	for i := range keys {
+		if keys[i] == key {
+			return keys
+		}
+	}
+
+	return append(keys, key)
+}
+
+// Records the counts of a referral moving from oldStatus to newStatus under the day the referral was
+// created, so a referral is counted in the same day through the whole funnel. history ends with the
+// change being counted. Updates that leave the status unchanged, and statuses the referral had entered
+// before, are not counted
+func countStatusChange(referralKey string, keys []StatKey, history []StatusChange, oldStatus string, newStatus string, stub shim.ChaincodeStubInterface) error {
+	if oldStatus == newStatus {
+		return nil
+	}
+
+	counts := map[string]int64{}
+	if oldStatus == "" {
+		counts[StatCreated] = 1
+	}
+
+	earlier := history
+	if len(earlier) > 0 {
+		earlier = earlier[:len(earlier) - 1]
+	}
+
+	if !enteredStatus(earlier, newStatus) {
+		counts[newStatus] = 1
+	}
+
+	if (newStatus == AcceptedStatus || newStatus == "CLOSED") && !enteredStatus(earlier, AcceptedStatus) && !enteredStatus(earlier, "CLOSED") {
+		counts[StatAccepted] = 1
+	}
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	// Referrals created before status histories were kept are counted on the day of the change
+	created := int64(0)
+	if len(history) > 0 {
+		created = history[0].Timestamp
+	} else {
+		timestamp, err := txTimeSeconds(stub)
+		if err != nil {
+			return err
+		}
+		created = timestamp
+	}
+
+	day := time.Unix(created, 0).UTC().Format("20060102")
+
+	for _, statKey := range keys {
+		counter := StatCounter{Day: day, Dimension: statKey.Dimension, Value: statKey.Value, Counts: counts}
+		key := statsKeyPrefix + statKey.Dimension + "_" + day + "_" + statKey.Value + "_" + stub.GetTxID() + "_" + referralKey
+
+		valAsbytes, err := json.Marshal(counter)
+		if err != nil {
+			return Internal("Failed to marshal counter " + key, err)
+		}
+
+		err = stub.PutState(key, valAsbytes)
+		if err != nil {
+			return Internal("Failed to update state for " + key, err)
+		}
+	}
+
+	return nil
+}
+
+func enteredStatus(history []StatusChange, status string) bool {
+	for i := range history {
+		if history[i].Status == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReferralStatsQuery returns the referralStats query function of a chaincode, reading its referrals with
+// readStages to find the expired ones
+func ReferralStatsQuery(readStages ReadReferralStages) ChaincodeFunction {
+	return func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+		return referralStats(stub, args, readStages)
+	}
+}
+
+// referralStats - query function returning the referral funnel of each value of a dimension. Optional
+// arguments limit the counts to the referrals created from and to the days given, inclusive, as YYYY-MM-DD
+func referralStats(stub shim.ChaincodeStubInterface, args []string, readStages ReadReferralStages) ([]byte, error) {
+	var report StatsReport
+
+	fmt.Println("running referralStats()")
+
+	if len(args) < 1 || len(args) > 3 {
+		return nil, WrongArgumentCount("the dimension and optionally the first and last day")
+	}
+
+	report.Dimension = args[0]
+	err := ValidateEnum("dimension", report.Dimension, StatDimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 1 {
+		report.From = args[1]
+	}
+	if len(args) > 2 {
+		report.To = args[2]
+	}
+
+	start, end, err := ParseDayRange(report.From, report.To)
+	if err != nil {
+		return nil, err
+	}
+
+	dimensionPrefix := statsKeyPrefix + report.Dimension + "_"
+	startKey, endKey := dimensionPrefix, dimensionPrefix + "~"
+	if start != 0 {
+		startKey = dimensionPrefix + time.Unix(start, 0).UTC().Format("20060102")
+	}
+	if end != 0 {
+		endKey = dimensionPrefix + time.Unix(end + 1, 0).UTC().Format("20060102")
+	}
+
+	iterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, Internal("Failed to read the referral statistics", err)
+	}
+	defer iterator.Close()
+
+	counts := map[string]map[string]int64{}
+	for iterator.HasNext() {
+		var counter StatCounter
+
+		result, err := iterator.Next()
+		if err != nil {
+			return nil, Internal("Failed to read the referral statistics", err)
+		}
+
+		err = json.Unmarshal(result.Value, &counter)
+		if err != nil {
+			return nil, Internal("Failed to read counter " + result.Key, err)
+		}
+
+		if counts[counter.Value] == nil {
+			counts[counter.Value] = map[string]int64{}
+		}
+		for status, count := range counter.Counts {
+			counts[counter.Value][status] += count
+		}
+	}
+
+	report.ExpiryDays, err = countExpired(report.Dimension, start, end, counts, readStages, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Groups = []FunnelStats{}
+	for value, valueCounts := range counts {
+		report.Groups = append(report.Groups, funnel(value, valueCounts))
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Value < report.Groups[j].Value
+	})
+
+	return json.Marshal(report)
+}
+
+// Counts the open referrals created between start and end that are older than the configured expiry
+// age now, under StatExpired of their dimension values, and returns the age in days
+func countExpired(dimension string, start int64, end int64, counts map[string]map[string]int64, readStages ReadReferralStages, stub shim.ChaincodeStubInterface) (int, error) {
+	config, err := LoadConfig(stub)
+	if err != nil {
+		return 0, err
+	}
+
+	expiryDays := config.ExpiryDays
+	if expiryDays == 0 {
+		expiryDays = DefaultExpiryDays
+	}
+
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, status := range ReferralStatuses {
+		if !IsOpenStatus(status) {
+			continue
+		}
+
+		valAsbytes, err := stub.GetState(status)
+		if err != nil {
+			return 0, Internal("Failed to get state for " + status, err)
+		}
+
+		for _, referralId := range SplitReferralIds(BytesToString(valAsbytes)) {
+			valAsbytes, err := stub.GetState(referralId)
+			if err != nil {
+				return 0, Internal("Failed to get state for " + referralId, err)
+			}
+
+			if valAsbytes == nil {
+				continue
+			}
+
+			stages, err := readStages(valAsbytes, stub)
+			if err != nil {
+				return 0, Internal("Failed to read referral " + referralId, err)
+			}
+
+			if len(stages.History) == 0 {
+				continue
+			}
+
+			created := stages.History[0].Timestamp
+			if created < start || (end != 0 && created > end) || now - created <= int64(expiryDays) * 24 * 60 * 60 {
+				continue
+			}
+
+			for _, key := range stages.Keys {
+				if key.Dimension != dimension {
+					continue
+				}
+
+				if counts[key.Value] == nil {
+					counts[key.Value] = map[string]int64{}
+				}
+				counts[key.Value][StatExpired]++
+			}
+		}
+	}
+
+	return expiryDays, nil
+}
+
+func funnel(value string, counts map[string]int64) FunnelStats {
+	stats := FunnelStats{Value: value, Counts: counts}
+
+	created := counts[StatCreated]
+	if created > 0 {
+		stats.AcceptanceRate = float64(counts[StatAccepted]) / float64(created)
+		stats.CloseRate = float64(counts["CLOSED"]) / float64(created)
+		stats.DeclineRate = float64(counts["DECLINED"]) / float64(created)
+		stats.ExpiryRate = float64(counts[StatExpired]) / float64(created)
+	}
+
+	if counts[StatAccepted] > 0 {
+		stats.AcceptedCloseRate = float64(counts["CLOSED"]) / float64(counts[StatAccepted])
+	}
+
+	return stats
+}
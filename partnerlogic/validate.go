@@ -0,0 +1,203 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+import (
+	"encoding/json"
+	"strings"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The customer sizes partner commission tables are indexed by
+var CustomerSizes = []string{"MICRO", "SMALL", "MID", "LARGE"}
+
+// Every referral starts in this status, whatever the caller asks for
+const InitialStatus = "ACTIVE"
+
+// The keys and key prefixes the chaincodes keep their own records under. The status indexes are
+// reserved as well, so a referral id can never overwrite a record that is not a referral
+var reservedKeys = append([]string{configKey, partnersKey}, ReferralStatuses...)
+var reservedKeyPrefixes = []string{auditKeyPrefix, statsKeyPrefix, statementKeyPrefix, partnerKeyPrefix, departmentKeyPrefix}
+
+// ReserveKeys keeps referral ids off the keys and key prefixes a chaincode stores its own records under
+func ReserveKeys(keys []string, prefixes []string) {
+	reservedKeys = append(reservedKeys, keys...)
+	reservedKeyPrefixes = append(reservedKeyPrefixes, prefixes...)
+}
+
+// DecodeStrict unmarshals exactly one JSON value into v, rejecting fields v does not have. Failures are
+// reported as INVALID_ARGUMENT naming the offending field where there is one
+func DecodeStrict(data string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return InvalidArgument(typeErr.Field, "Field " + typeErr.Field + " must be a " + typeErr.Type.String())
+		}
+
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), "\"")
+			return InvalidArgument(field, "Unknown field " + field)
+		}
+
+		return InvalidArgument("", "Invalid JSON: " + err.Error())
+	}
+
+	if decoder.More() {
+		return InvalidArgument("", "Invalid JSON: unexpected data after the value")
+	}
+
+	return nil
+}
+
+// Required rejects an empty value
+func Required(field string, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return InvalidArgument(field, "Field " + field + " is required")
+	}
+
+	return nil
+}
+
+// ValidateEnum rejects a value that is not one of the allowed values
+func ValidateEnum(field string, value string, allowed []string) error {
+	for i := range allowed {
+		if value == allowed[i] {
+			return nil
+		}
+	}
+
+	return InvalidArgument(field, "Field " + field + " must be one of " + strings.Join(allowed, ", ")).WithDetail("value", value)
+}
+
+// ValidateCustomerReferral checks a new bank referral before it is written under referralKey. Fields the
+// chaincode fills in itself may not be supplied
+func ValidateCustomerReferral(referralKey string, referral CustomerReferral) error {
+	for _, err := range []error{
+		Required("referralId", referral.ReferralId),
+		Required("customerName", referral.CustomerName),
+		Required("contactNumber", referral.ContactNumber),
+		Required("customerId", referral.CustomerId),
+		Required("employeeId", referral.EmployeeId),
+		validateInitialStatus(referral.Status),
+		serverOwned("partnerReferralIds", referral.PartnerReferralIds != nil),
+		serverOwned("partnerStatuses", referral.PartnerStatuses != nil),
+		serverOwned("partnerCompensation", referral.PartnerCompensation != nil),
+		serverOwned("statusHistory", referral.StatusHistory != nil),
+		serverOwned("pii", referral.PII != nil),
+		serverOwned("customerHash", referral.CustomerHash != ""),
+		serverOwned("duplicateOf", referral.DuplicateOf != ""),
+		serverOwned("redaction", referral.Redaction != nil),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	if referral.ReferralId != referralKey {
+		return InvalidArgument("referralId", "Referral id " + referral.ReferralId + " does not match the key " + referralKey)
+	}
+
+	if len(referral.Departments) == 0 {
+		return InvalidArgument("departments", "A referral needs at least one department")
+	}
+
+	for i := range referral.Departments {
+		err := Required("departments", referral.Departments[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidatePartnerReferral checks a new partner referral before it is written under referralKey. The
+// customer size is optional, as the bank does not know it when it forwards a referral. The bank referral
+// id is the key the bank forwards the referral under, so it is filled in rather than supplied
+func ValidatePartnerReferral(referralKey string, referral PartnerReferral) error {
+	for _, err := range []error{
+		Required("referralId", referral.ReferralId),
+		Required("customerName", referral.CustomerName),
+		validateInitialStatus(referral.Status),
+		serverOwned("compensation", referral.Compensation != nil),
+		serverOwned("dealCriteria", referral.DealCriteria != ""),
+		serverOwned("bankReferralId", referral.BankReferralId != ""),
+		serverOwned("statusHistory", referral.StatusHistory != nil),
+		serverOwned("pii", referral.PII != nil),
+		serverOwned("redaction", referral.Redaction != nil),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	if referral.ReferralId != referralKey {
+		return InvalidArgument("referralId", "Referral id " + referral.ReferralId + " does not match the key " + referralKey)
+	}
+
+	if referral.CustomerSize != "" {
+		return ValidateEnum("customerSize", referral.CustomerSize, CustomerSizes)
+	}
+
+	return nil
+}
+
+// validateInitialStatus accepts an empty status, which the create fills in, or the initial status
+func validateInitialStatus(status string) error {
+	if status != "" && status != InitialStatus {
+		return InvalidArgument("status", "New referrals start " + InitialStatus).WithDetail("value", status)
+	}
+
+	return nil
+}
+
+// serverOwned rejects a field the chaincode sets itself when the caller supplied it
+func serverOwned(field string, supplied bool) error {
+	if supplied {
+		return InvalidArgument(field, "Field " + field + " is set by the chaincode")
+	}
+
+	return nil
+}
+
+// EnsureNewReferral rejects a create for a key that is already in use, or that is one of the
+// chaincode's own records
+func EnsureNewReferral(referralKey string, stub shim.ChaincodeStubInterface) error {
+	for _, key := range reservedKeys {
+		if referralKey == key {
+			return InvalidArgument("referralId", "Referral id " + referralKey + " is reserved")
+		}
+	}
+
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(referralKey, prefix) {
+			return InvalidArgument("referralId", "Referral ids may not start with " + prefix)
+		}
+	}
+
+	valAsbytes, err := stub.GetState(referralKey)
+	if err != nil {
+		return Internal("Failed to get state for " + referralKey, err)
+	}
+
+	if valAsbytes != nil {
+		return Conflict("Referral " + referralKey + " already exists")
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package partnerlogic
+
+// VantivAdapter implements the Vantiv merchant services partner
+type VantivAdapter struct {
+}
+
+// Commission paid by Vantiv, indexed by deal size then customer size
+var vantivClosingCommission = [3][4]int64{
+	{250, 300, 350, 400},
+	{1000, 1250, 1500, 1750},
+	{2000, 2500, 3000, 3500},
+}
+
+func (a VantivAdapter) PartnerName() string {
+	return "Vantiv"
+}
+
+func (a VantivAdapter) ValidateReferral(referral PartnerReferral) error {
+	return ValidatePartnerName(referral, a.PartnerName())
+}
+
+func (a VantivAdapter) ComputeCompensation(referral PartnerReferral, dealCriteria string) (int64, error) {
+	return TieredCommission(vantivClosingCommission, dealCriteria, referral.CustomerSize), nil
+}
+
+func (a VantivAdapter) MapFields(referral CustomerReferral) (PartnerReferral, error) {
+	return MapBankReferral(referral, a.PartnerName())
+}
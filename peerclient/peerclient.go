@@ -0,0 +1,429 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// Package peerclient calls chaincodes through a peer's Fabric Gateway service. It builds and signs the
+// proposals, transactions and requests itself on top of the gateway protos, so it can be linked into
+// programs that also carry the chaincode shim
+package peerclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/gateway"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/orderer"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Config locates a peer's gateway service and the identity that signs for the client. TLSCertPath is the
+// CA certificate the peer's TLS certificate is checked against; without it the connection is not
+// encrypted, which only suits development networks. CertPath and KeyPath hold the PEM encoded
+// certificate and ECDSA private key of an enrolled identity of MspId
+type Config struct {
+	Endpoint string
+	TLSCertPath string
+	ServerName string
+	MspId string
+	CertPath string
+	KeyPath string
+	Channel string
+}
+
+// Client sends signed proposals and requests to one peer's gateway service. Timeout bounds each
+// evaluate and each submit, including the wait for the transaction to commit
+type Client struct {
+	Channel string
+	Timeout time.Duration
+	conn *grpc.ClientConn
+	gateway gateway.GatewayClient
+	creator []byte
+	key *ecdsa.PrivateKey
+}
+
+// Error is a call the gateway service refused. Details holds the messages of the peers and orderers it
+// reached, which carry the chaincode's own error message
+type Error struct {
+	Call string
+	Message string
+	Details []string
+}
+
+func (e *Error) Error() string {
+	message := e.Call + ": " + e.Message
+	for _, detail := range e.Details {
+		message += "; " + detail
+	}
+
+	return message
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func Connect(config Config) (*Client, error) {
+	if config.Endpoint == "" || config.MspId == "" || config.CertPath == "" || config.KeyPath == "" || config.Channel == "" {
+		return nil, errors.New("the endpoint, MSP id, certificate, key and channel are required")
+	}
+
+	certPEM, err := os.ReadFile(config.CertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(config.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: config.MspId, IdBytes: certPEM})
+	if err != nil {
+		return nil, err
+	}
+
+	transport := insecure.NewCredentials()
+	if config.TLSCertPath != "" {
+		caPEM, err := os.ReadFile(config.TLSCertPath)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificate found in " + config.TLSCertPath)
+		}
+		transport = credentials.NewClientTLSFromCert(pool, config.ServerName)
+	}
+
+	conn, err := grpc.Dial(config.Endpoint, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		Channel: config.Channel,
+		Timeout: time.Minute,
+		conn: conn,
+		gateway: gateway.NewGatewayClient(conn),
+		creator: creator,
+		key: key,
+	}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Evaluate runs a query on the peer and returns its result. Nothing is sent to be ordered
+func (c *Client) Evaluate(chaincodeName string, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	txId, proposal, err := c.newProposal(chaincodeName, function, args, transient)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	response, err := c.gateway.Evaluate(ctx, &gateway.EvaluateRequest{TransactionId: txId, ChannelId: c.Channel, ProposedTransaction: proposal})
+	if err != nil {
+		return nil, callError("evaluate " + function, err)
+	}
+
+	if response.Result.GetStatus() >= 400 {
+		return nil, &Error{Call: "evaluate " + function, Message: response.Result.GetMessage()}
+	}
+
+	return response.Result.GetPayload(), nil
+}
+
+// Submit has a transaction endorsed, sends it to be ordered and waits for it to commit. It returns the
+// transaction id and the chaincode's result, and an error unless the transaction committed as valid
+func (c *Client) Submit(chaincodeName string, function string, args []string, transient map[string][]byte) (string, []byte, error) {
+	txId, proposal, err := c.newProposal(chaincodeName, function, args, transient)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	endorsed, err := c.gateway.Endorse(ctx, &gateway.EndorseRequest{TransactionId: txId, ChannelId: c.Channel, ProposedTransaction: proposal})
+	if err != nil {
+		return "", nil, callError("endorse " + function, err)
+	}
+
+	envelope := endorsed.PreparedTransaction
+	result, err := transactionResult(envelope)
+	if err != nil {
+		return "", nil, err
+	}
+
+	envelope.Signature, err = c.sign(envelope.Payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, err = c.gateway.Submit(ctx, &gateway.SubmitRequest{TransactionId: txId, ChannelId: c.Channel, PreparedTransaction: envelope})
+	if err != nil {
+		return "", nil, callError("submit " + function, err)
+	}
+
+	request, err := proto.Marshal(&gateway.CommitStatusRequest{TransactionId: txId, ChannelId: c.Channel, Identity: c.creator})
+	if err != nil {
+		return "", nil, err
+	}
+
+	signature, err := c.sign(request)
+	if err != nil {
+		return "", nil, err
+	}
+
+	committed, err := c.gateway.CommitStatus(ctx, &gateway.SignedCommitStatusRequest{Request: request, Signature: signature})
+	if err != nil {
+		return "", nil, callError("commit status " + function, err)
+	}
+
+	if committed.Result != peer.TxValidationCode_VALID {
+		return txId, nil, fmt.Errorf("transaction %s was not committed: %s", txId, committed.Result)
+	}
+
+	return txId, result, nil
+}
+
+// ChaincodeEvents streams the events a chaincode set in valid transactions, from block startBlock on,
+// passing handle the events of each block in the order they were committed. It returns when the context
+// is cancelled, the stream fails or handle returns an error
+func (c *Client) ChaincodeEvents(ctx context.Context, chaincodeName string, startBlock uint64, handle func(blockNumber uint64, events []*peer.ChaincodeEvent) error) error {
+	request, err := proto.Marshal(&gateway.ChaincodeEventsRequest{
+		ChannelId: c.Channel,
+		ChaincodeId: chaincodeName,
+		Identity: c.creator,
+		StartPosition: &orderer.SeekPosition{
+			Type: &orderer.SeekPosition_Specified{Specified: &orderer.SeekSpecified{Number: startBlock}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	signature, err := c.sign(request)
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.gateway.ChaincodeEvents(ctx, &gateway.SignedChaincodeEventsRequest{Request: request, Signature: signature})
+	if err != nil {
+		return callError("chaincode events", err)
+	}
+
+	for {
+		response, err := stream.Recv()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return callError("chaincode events", err)
+		}
+
+		err = handle(response.BlockNumber, response.Events)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// newProposal builds and signs a proposal to invoke function on a chaincode. The transaction id is
+// derived from a fresh nonce and the creator, as peers check
+func (c *Client) newProposal(chaincodeName string, function string, args []string, transient map[string][]byte) (string, *peer.SignedProposal, error) {
+	nonce := make([]byte, 24)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	txHash := sha256.Sum256(append(nonce, c.creator...))
+	txId := hex.EncodeToString(txHash[:])
+	chaincodeId := &peer.ChaincodeID{Name: chaincodeName}
+
+	extension, err := proto.Marshal(&peer.ChaincodeHeaderExtension{ChaincodeId: chaincodeId})
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{
+		Type: int32(common.HeaderType_ENDORSER_TRANSACTION),
+		ChannelId: c.Channel,
+		TxId: txId,
+		Timestamp: &timestamp.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())},
+		Extension: extension,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	signatureHeader, err := proto.Marshal(&common.SignatureHeader{Creator: c.creator, Nonce: nonce})
+	if err != nil {
+		return "", nil, err
+	}
+
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader})
+	if err != nil {
+		return "", nil, err
+	}
+
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+
+	invocation, err := proto.Marshal(&peer.ChaincodeInvocationSpec{
+		ChaincodeSpec: &peer.ChaincodeSpec{
+			Type: peer.ChaincodeSpec_GOLANG,
+			ChaincodeId: chaincodeId,
+			Input: &peer.ChaincodeInput{Args: input},
+		},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	payload, err := proto.Marshal(&peer.ChaincodeProposalPayload{Input: invocation, TransientMap: transient})
+	if err != nil {
+		return "", nil, err
+	}
+
+	proposal, err := proto.Marshal(&peer.Proposal{Header: header, Payload: payload})
+	if err != nil {
+		return "", nil, err
+	}
+
+	signature, err := c.sign(proposal)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return txId, &peer.SignedProposal{ProposalBytes: proposal, Signature: signature}, nil
+}
+
+// transactionResult returns the chaincode's result from an endorsed transaction
+func transactionResult(envelope *common.Envelope) ([]byte, error) {
+	var payload common.Payload
+	var transaction peer.Transaction
+	var actionPayload peer.ChaincodeActionPayload
+	var responsePayload peer.ProposalResponsePayload
+	var action peer.ChaincodeAction
+
+	err := proto.Unmarshal(envelope.Payload, &payload)
+	if err == nil {
+		err = proto.Unmarshal(payload.Data, &transaction)
+	}
+	if err == nil && len(transaction.Actions) == 0 {
+		err = errors.New("the transaction has no actions")
+	}
+	if err == nil {
+		err = proto.Unmarshal(transaction.Actions[0].Payload, &actionPayload)
+	}
+	if err == nil {
+		err = proto.Unmarshal(actionPayload.Action.GetProposalResponsePayload(), &responsePayload)
+	}
+	if err == nil {
+		err = proto.Unmarshal(responsePayload.Extension, &action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the endorsed transaction: %v", err)
+	}
+
+	return action.Response.GetPayload(), nil
+}
+
+// sign returns the DER encoded ECDSA signature of the message's SHA-256 hash. Fabric only accepts the
+// low S form of a signature, so a high S is replaced by its complement
+func (c *Client) sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	order := c.key.Params().N
+	if s.Cmp(new(big.Int).Rsh(order, 1)) > 0 {
+		s.Sub(order, s)
+	}
+
+	return asn1.Marshal(ecdsaSignature{R: r, S: s})
+}
+
+// parsePrivateKey reads a PKCS #8 or SEC 1 ECDSA private key, the forms Fabric CAs and cryptogen write
+func parsePrivateKey(keyPEM []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key is not an ECDSA key")
+	}
+
+	return ecdsaKey, nil
+}
+
+// callError turns a gRPC error from the gateway service into an Error carrying the messages of the
+// peers and orderers involved
+func callError(call string, err error) error {
+	callStatus, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	gatewayErr := &Error{Call: call, Message: callStatus.Message()}
+	for _, detail := range callStatus.Proto().GetDetails() {
+		var errorDetail gateway.ErrorDetail
+		if ptypes.UnmarshalAny(detail, &errorDetail) == nil {
+			gatewayErr.Details = append(gatewayErr.Details, errorDetail.Message)
+		}
+	}
+
+	return gatewayErr
+}
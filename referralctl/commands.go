@@ -0,0 +1,334 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/joerust/referral-partners/gateway"
+	"gopkg.in/yaml.v2"
+)
+
+func createReferral(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	file := flags.String("f", "", "JSON or YAML file describing the referral, - for standard input")
+	flags.Parse(args)
+
+	if *file == "" {
+		return errors.New("-f is required")
+	}
+
+	valAsbytes, err := readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	if isYAML(*file, valAsbytes) {
+		valAsbytes, err = yamlToJSON(valAsbytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	referral, err := gateway.DecodeCreateReferral(bytes.NewReader(valAsbytes))
+	if err != nil {
+		return err
+	}
+
+	txId, err := backend.CreateReferral(referral)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created referral %s in transaction %s\n", referral.ReferralId, txId)
+	return nil
+}
+
+func setStatus(backend gateway.Backend, args []string) error {
+	if len(args) != 2 {
+		return errors.New("expecting a referral id and a status")
+	}
+
+	txId, err := backend.UpdateStatus(args[0], strings.ToUpper(args[1]))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated referral %s in transaction %s\n", args[0], txId)
+	return nil
+}
+
+func closeDeal(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("close", flag.ExitOnError)
+	partner := flags.String("partner", "", "partner closing the deal")
+	deal := flags.String("deal", "", "deal criteria, such as SMALL, MID or LARGE, or the funded amount for mortgages")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *partner == "" || *deal == "" {
+		return errors.New("expecting -partner, -deal and a referral id")
+	}
+
+	txId, err := backend.CloseDeal(flags.Arg(0), *partner, *deal)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Closed referral %s in transaction %s\n", flags.Arg(0), txId)
+	return nil
+}
+
+func syncPartner(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	partner := flags.String("partner", "", "partner whose copy of the referral is read back")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *partner == "" {
+		return errors.New("expecting -partner and a referral id")
+	}
+
+	txId, err := backend.SyncPartner(flags.Arg(0), *partner)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Synced referral %s with %s in transaction %s\n", flags.Arg(0), *partner, txId)
+	return nil
+}
+
+func showReferral(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	output := flags.String("o", "table", "output format: table or json")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("expecting a referral id")
+	}
+
+	referral, err := backend.GetReferral(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		return writeJSON(os.Stdout, referral)
+	case "table":
+		return writeReferral(os.Stdout, referral)
+	}
+
+	return errors.New("unknown output format " + *output)
+}
+
+func listReferrals(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", "", "only list referrals in this status")
+	output := flags.String("o", "table", "output format: table, json or csv")
+	flags.Parse(args)
+
+	referrals, err := backend.ListReferrals(strings.ToUpper(*status))
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		return writeJSON(os.Stdout, referrals)
+	case "csv":
+		return writeCSV(os.Stdout, referrals)
+	case "table":
+		return writeTable(os.Stdout, referrals)
+	}
+
+	return errors.New("unknown output format " + *output)
+}
+
+func stageDurations(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("durations", flag.ExitOnError)
+	dimension := flags.String("by", "partner", "group by all, partner, employee or branch")
+	partnerName := flags.String("partner", "", "report the referrals on this partner's chaincode")
+	from := flags.String("from", "", "first day, as YYYY-MM-DD")
+	to := flags.String("to", "", "last day, as YYYY-MM-DD")
+	output := flags.String("o", "table", "output format: table or json")
+	flags.Parse(args)
+
+	report, err := backend.StageDurations(strings.ToLower(*dimension), *partnerName, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		return writeJSON(os.Stdout, report)
+	case "table":
+		return writeDurations(os.Stdout, report)
+	}
+
+	return errors.New("unknown output format " + *output)
+}
+
+// exportReferrals writes each referral as the backend passes it on, so large exports are not held in memory
+func exportReferrals(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	status := flags.String("status", "", "only export referrals in this status")
+	from := flags.String("from", "", "first day the referrals were created, as YYYY-MM-DD")
+	to := flags.String("to", "", "last day the referrals were created, as YYYY-MM-DD")
+	output := flags.String("o", "csv", "output format: csv or jsonl")
+	flags.Parse(args)
+
+	filter := gateway.ExportFilter{Status: strings.ToUpper(*status), From: *from, To: *to}
+	err := filter.Validate()
+	if err != nil {
+		return err
+	}
+
+	exporter, err := gateway.NewExportWriter(os.Stdout, *output)
+	if err != nil {
+		return err
+	}
+
+	err = backend.ExportReferrals(filter, exporter.Write)
+	if err != nil {
+		exporter.Flush()
+		return err
+	}
+
+	return exporter.Flush()
+}
+
+func showStatement(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("statement", flag.ExitOnError)
+	partnerName := flags.String("partner", "", "partner the statement is for")
+	period := flags.String("period", "", "month, as YYYY-MM")
+	output := flags.String("o", "table", "output format: table, json or csv")
+	flags.Parse(args)
+
+	if *partnerName == "" || *period == "" {
+		return errors.New("expecting -partner and -period")
+	}
+
+	statement, err := backend.Statement(*partnerName, *period)
+	if err != nil {
+		return err
+	}
+
+	switch *output {
+	case "json":
+		return writeJSON(os.Stdout, statement)
+	case "csv":
+		return gateway.WriteStatementCSV(os.Stdout, statement)
+	case "table":
+		return writeStatement(os.Stdout, statement)
+	}
+
+	return errors.New("unknown output format " + *output)
+}
+
+func recordStatement(backend gateway.Backend, args []string) error {
+	flags := flag.NewFlagSet("record-statement", flag.ExitOnError)
+	partnerName := flags.String("partner", "", "partner the statement is for")
+	period := flags.String("period", "", "month, as YYYY-MM")
+	flags.Parse(args)
+
+	if *partnerName == "" || *period == "" {
+		return errors.New("expecting -partner and -period")
+	}
+
+	txId, err := backend.RecordStatement(*partnerName, *period)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recorded the %s statement of %s in transaction %s\n", *period, *partnerName, txId)
+	return nil
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "-" {
+		var buffer bytes.Buffer
+		_, err := buffer.ReadFrom(os.Stdin)
+		return buffer.Bytes(), err
+	}
+
+	return os.ReadFile(file)
+}
+
+// Files are YAML when their extension says so, or, for standard input, when they do not look like JSON
+func isYAML(file string, content []byte) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	return len(trimmed) > 0 && trimmed[0] != '{'
+}
+
+// yamlToJSON converts a YAML document to JSON so it goes through the same strict decoding as JSON input
+func yamlToJSON(content []byte) ([]byte, error) {
+	var document interface{}
+
+	err := yaml.Unmarshal(content, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := jsonValue(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(converted)
+}
+
+// yaml.v2 decodes mappings with interface{} keys, which encoding/json cannot marshal
+func jsonValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range v {
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("YAML key %v is not a string", key)
+			}
+
+			convertedItem, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[keyString] = convertedItem
+		}
+		return converted, nil
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			convertedItem, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = convertedItem
+		}
+		return converted, nil
+	}
+
+	return value, nil
+}
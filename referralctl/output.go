@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"github.com/joerust/referral-partners/partnerlogic"
+)
+
+var listColumns = []string{"REFERRAL ID", "STATUS", "CUSTOMER ID", "EMPLOYEE ID", "DEPARTMENTS", "CREATED"}
+
+func listRow(referral partnerlogic.CustomerReferral) []string {
+	return []string{
+		referral.ReferralId,
+		referral.Status,
+		referral.CustomerId,
+		referral.EmployeeId,
+		strings.Join(referral.Departments, ";"),
+		formatTime(referral.CreateDate),
+	}
+}
+
+func formatTime(seconds int64) string {
+	if seconds == 0 {
+		return ""
+	}
+
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func writeTable(w io.Writer, referrals []partnerlogic.CustomerReferral) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(listColumns, "\t"))
+	for _, referral := range referrals {
+		fmt.Fprintln(tw, strings.Join(listRow(referral), "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, referrals []partnerlogic.CustomerReferral) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write(listColumns)
+	for _, referral := range referrals {
+		cw.Write(listRow(referral))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeReferral prints a single referral followed by its status history and partner details
+func writeReferral(w io.Writer, referral partnerlogic.CustomerReferral) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Referral:\t%s\n", referral.ReferralId)
+	fmt.Fprintf(tw, "Status:\t%s\n", referral.Status)
+	fmt.Fprintf(tw, "Customer:\t%s (%s)\n", referral.CustomerName, referral.CustomerId)
+	fmt.Fprintf(tw, "Contact number:\t%s\n", referral.ContactNumber)
+	fmt.Fprintf(tw, "Employee:\t%s\n", referral.EmployeeId)
+	if referral.BranchId != "" {
+		fmt.Fprintf(tw, "Branch:\t%s\n", referral.BranchId)
+	}
+	fmt.Fprintf(tw, "Departments:\t%s\n", strings.Join(referral.Departments, ", "))
+	fmt.Fprintf(tw, "Created:\t%s\n", formatTime(referral.CreateDate))
+
+	if referral.DuplicateOf != "" {
+		fmt.Fprintf(tw, "Duplicate of:\t%s\n", referral.DuplicateOf)
+	}
+
+	if referral.Consent != nil {
+		fmt.Fprintf(tw, "Consent:\t%s via %s by %s at %s\n", strings.Join(referral.Consent.Scope, ", "), referral.Consent.Channel, referral.Consent.CapturedBy, formatTime(referral.Consent.Timestamp))
+	}
+
+	if referral.Mortgage != nil {
+		fmt.Fprintf(tw, "Mortgage:\t%s %s, %s at %s\n", referral.Mortgage.MortgageNumber, referral.Mortgage.MortgageType, referral.Mortgage.Amount, referral.Mortgage.Rate)
+	}
+
+	var partnerNames []string
+	for partnerName := range referral.PartnerReferralIds {
+		partnerNames = append(partnerNames, partnerName)
+	}
+	sort.Strings(partnerNames)
+
+	for _, partnerName := range partnerNames {
+		fmt.Fprintf(tw, "Partner %s:\t%s %s", partnerName, referral.PartnerReferralIds[partnerName], referral.PartnerStatuses[partnerName])
+		if compensation, paid := referral.PartnerCompensation[partnerName]; paid {
+			fmt.Fprintf(tw, ", compensation %s", strconv.FormatInt(compensation, 10))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	if len(referral.StatusHistory) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "HISTORY\tSTATUS\tTRANSACTION")
+		for _, change := range referral.StatusHistory {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", formatTime(change.Timestamp), change.Status, change.TxId)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// writeDurations prints a row for each stage of each group of a stage duration report
+func writeDurations(w io.Writer, report partnerlogic.DurationReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.ToUpper(report.Dimension) + "\tSTAGE\tCOUNT\tMEDIAN\tP90\tMAX")
+	for _, group := range report.Groups {
+		for _, stage := range group.Stages {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", group.Value, stage.Stage, stage.Count, formatDuration(stage.Median), formatDuration(stage.P90), formatDuration(stage.Max))
+		}
+	}
+
+	return tw.Flush()
+}
+
+func formatDuration(seconds int64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+
+// writeStatement prints the closed deals and reversals of a statement followed by its totals and hash
+func writeStatement(w io.Writer, statement partnerlogic.Statement) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Partner:\t%s\n", statement.PartnerName)
+	fmt.Fprintf(tw, "Period:\t%s\n", statement.Period)
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "TYPE\tREFERRAL ID\tBANK REFERRAL ID\tDATE\tDEAL\tCUSTOMER SIZE\tCOMPENSATION")
+	for _, line := range statement.ClosedDeals {
+		fmt.Fprintf(tw, "CLOSED\t%s\t%s\t%s\t%s\t%s\t%d\n", line.ReferralId, line.BankReferralId, formatTime(line.Timestamp), line.DealCriteria, line.CustomerSize, line.Compensation)
+	}
+	for _, line := range statement.Reversals {
+		fmt.Fprintf(tw, "REVERSAL\t%s\t%s\t%s\t%s\t%s\t%d\n", line.ReferralId, line.BankReferralId, formatTime(line.Timestamp), line.DealCriteria, line.CustomerSize, -line.Compensation)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "Closed deals:\t%d, compensation %d\n", statement.Totals.ClosedDeals, statement.Totals.Compensation)
+	fmt.Fprintf(tw, "Reversals:\t%d, compensation %d\n", statement.Totals.Reversals, statement.Totals.ReversedCompensation)
+	fmt.Fprintf(tw, "Net compensation:\t%d\n", statement.Totals.NetCompensation)
+	fmt.Fprintf(tw, "Hash:\t%s\n", statement.Hash)
+
+	if statement.Record != nil {
+		recorded := "matches"
+		if statement.Record.Hash != statement.Hash {
+			recorded = "DIFFERS, recorded " + statement.Record.Hash
+		}
+		fmt.Fprintf(tw, "Recorded hash:\t%s\n", recorded)
+		for _, confirmation := range statement.Record.Confirmations {
+			fmt.Fprintf(tw, "Confirmed by:\t%s (%s, %s) at %s\n", confirmation.ConfirmedBy, confirmation.Side, confirmation.MspId, formatTime(confirmation.Timestamp))
+		}
+		fmt.Fprintf(tw, "Confirmed by both sides:\t%t\n", statement.Record.Confirmed)
+	}
+
+	return tw.Flush()
+}
\ No newline at end of file
@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// referralctl manages referrals from the command line, either through a gateway or against a local
+// ledger file:
+//
+//	referralctl [-gateway url | -ledger file] create -f referral.yaml
+//	referralctl [-gateway url | -ledger file] set-status <referral id> <status>
+//	referralctl [-gateway url | -ledger file] close -partner Vantiv -deal MID <referral id>
+//	referralctl [-gateway url | -ledger file] sync -partner Vantiv <referral id>
+//	referralctl [-gateway url | -ledger file] show [-o table|json] <referral id>
+//	referralctl [-gateway url | -ledger file] list [-status ACTIVE] [-o table|json|csv]
+//	referralctl [-gateway url | -ledger file] durations [-by partner] [-partner Vantiv] [-from 2026-01-01] [-to 2026-03-31]
+//	referralctl [-gateway url | -ledger file] export [-status CLOSED] [-from 2026-01-01] [-to 2026-03-31] [-o csv|jsonl]
+//	referralctl [-gateway url | -ledger file] statement -partner Vantiv -period 2026-01 [-o table|json|csv]
+//	referralctl [-gateway url | -ledger file] record-statement -partner Vantiv -period 2026-01
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"github.com/joerust/referral-partners/gateway"
+)
+
+type command struct {
+	name string
+	usage string
+	run func(backend gateway.Backend, args []string) error
+}
+
+var commands = []command{
+	{"create", "create -f <referral.json|referral.yaml>", createReferral},
+	{"set-status", "set-status <referral id> <status>", setStatus},
+	{"close", "close -partner <partner> -deal <deal criteria> <referral id>", closeDeal},
+	{"sync", "sync -partner <partner> <referral id>", syncPartner},
+	{"show", "show [-o table|json] <referral id>", showReferral},
+	{"list", "list [-status <status>] [-o table|json|csv]", listReferrals},
+	{"durations", "durations [-by all|partner|employee|branch] [-partner <partner>] [-from <day>] [-to <day>] [-o table|json]", stageDurations},
+	{"export", "export [-status <status>] [-from <day>] [-to <day>] [-o csv|jsonl]", exportReferrals},
+	{"statement", "statement -partner <partner> -period <YYYY-MM> [-o table|json|csv]", showStatement},
+	{"record-statement", "record-statement -partner <partner> -period <YYYY-MM>", recordStatement},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: referralctl [-gateway url | -ledger file] <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintln(os.Stderr, "  " + c.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	gatewayURL := flag.String("gateway", "", "url of the referral gateway")
+	ledgerPath := flag.String("ledger", "", "local ledger file to use instead of a gateway")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var backend gateway.Backend
+
+	if *ledgerPath != "" && *gatewayURL != "" {
+		fatalf("Use either -gateway or -ledger, not both")
+	} else if *ledgerPath != "" {
+		localBackend, err := gateway.OpenLocalBackend(*ledgerPath)
+		if err != nil {
+			fatalf("Error opening ledger %s: %s", *ledgerPath, err)
+		}
+		backend = localBackend
+	} else {
+		if *gatewayURL == "" {
+			*gatewayURL = "http://localhost:8080"
+		}
+		backend = gateway.NewClient(*gatewayURL)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			err := c.run(backend, flag.Args()[1:])
+			if err != nil {
+				fatalf("%s: %s", name, err)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Unknown command " + name)
+	usage()
+	os.Exit(2)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format + "\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package relay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// FileCursor keeps an endpoint's position in a small JSON file. Saves replace the file atomically so a
+// crash leaves either the old or the new position, never a torn write
+type FileCursor struct {
+	Path string
+}
+
+// Load returns the saved position, or the zero position if nothing has been saved yet
+func (c FileCursor) Load() (Position, error) {
+	var position Position
+
+	valAsbytes, err := os.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return position, nil
+	}
+	if err != nil {
+		return position, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &position)
+	return position, err
+}
+
+func (c FileCursor) Save(position Position) error {
+	valAsbytes, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), filepath.Base(c.Path) + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(valAsbytes)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.Path)
+}
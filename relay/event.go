@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// Package relay delivers the referral chaincode events to HTTP webhooks. Each endpoint keeps its own
+// durable cursor into the chain, so a slow or unavailable endpoint never holds up the others and a
+// restarted relay resumes exactly where it stopped
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Event is a chaincode event together with its position on the chain. BlockNumber and EventIndex
+// identify the event uniquely and are what endpoint cursors record
+type Event struct {
+	BlockNumber uint64 `json:"blockNumber"`
+	EventIndex int `json:"eventIndex"`
+	ChaincodeId string `json:"chaincodeId"`
+	TxId string `json:"txId"`
+	EventName string `json:"eventName"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Id is the idempotency key sent with every delivery. Receivers should drop events whose id they have
+// already processed, since an event is delivered again if the relay stops before saving its cursor
+func (e Event) Id() string {
+	return fmt.Sprintf("%d-%d", e.BlockNumber, e.EventIndex)
+}
+
+// Position is where an endpoint's cursor points: the next event to deliver is event Index of block Block
+type Position struct {
+	Block uint64 `json:"block"`
+	Index int `json:"index"`
+}
+
+// After reports whether the event comes at or after the position, meaning it has not been delivered yet
+func (p Position) After(event Event) bool {
+	return event.BlockNumber > p.Block || (event.BlockNumber == p.Block && event.EventIndex >= p.Index)
+}
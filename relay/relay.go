@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var logf = log.Printf
+
+// Relay copies events from a Source to a set of endpoints. Cursors and dead letters for an endpoint
+// named "crm" are kept in StateDir as crm.cursor and crm.deadletter.jsonl. When an endpoint's event
+// stream fails it is opened again from the endpoint's cursor after RetryInterval
+type Relay struct {
+	Source Source
+	Endpoints []Endpoint
+	Deliverer *Deliverer
+	StateDir string
+	RetryInterval time.Duration
+}
+
+// Run relays events until the context is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	err := os.MkdirAll(r.StateDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range r.Endpoints {
+		wg.Add(1)
+		go func(endpoint Endpoint) {
+			defer wg.Done()
+			r.runEndpoint(ctx, endpoint)
+		}(endpoint)
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (r *Relay) runEndpoint(ctx context.Context, endpoint Endpoint) {
+	cursor := FileCursor{Path: filepath.Join(r.StateDir, endpoint.Name + ".cursor")}
+
+	for {
+		err := r.follow(ctx, endpoint, cursor)
+		if err != nil && ctx.Err() == nil {
+			logf("%s: %v", endpoint.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.RetryInterval):
+		}
+	}
+}
+
+// follow delivers the endpoint's events as they are committed, starting at its cursor and saving the
+// cursor after each one. The stream starts at the cursor's block, so the events of that block the
+// endpoint already has are skipped
+func (r *Relay) follow(ctx context.Context, endpoint Endpoint, cursor FileCursor) error {
+	position, err := cursor.Load()
+	if err != nil {
+		return err
+	}
+
+	return r.Source.Events(ctx, position.Block, func(event Event) error {
+		if !position.After(event) {
+			return nil
+		}
+
+		err := r.Deliverer.Deliver(ctx, endpoint, event)
+		if errors.Is(err, ErrRejected) {
+			logf("%s: %v, moving event %s to the dead letters", endpoint.Name, err, event.Id())
+			err = r.deadLetter(endpoint, event)
+		}
+		if err != nil {
+			return err
+		}
+
+		position = Position{Block: event.BlockNumber, Index: event.EventIndex + 1}
+		return cursor.Save(position)
+	})
+}
+
+// deadLetter keeps events an endpoint refused so they can be inspected and replayed by hand
+func (r *Relay) deadLetter(endpoint Endpoint, event Event) error {
+	valAsbytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(r.StateDir, endpoint.Name + ".deadletter.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(valAsbytes, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,220 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package relay_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"github.com/joerust/referral-partners/relay"
+)
+
+// endpointServer records the deliveries it receives and answers them with the given status codes in
+// turn, then with 200
+type endpointServer struct {
+	mu sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies [][]byte
+}
+
+func (s *endpointServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newDeliverer() *relay.Deliverer {
+	deliverer := relay.NewDeliverer()
+	deliverer.Backoff = relay.Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond}
+	deliverer.Now = func() time.Time { return time.Unix(1700000000, 0) }
+	return deliverer
+}
+
+func testEvent(block uint64, index int) relay.Event {
+	return relay.Event{
+		BlockNumber: block,
+		EventIndex: index,
+		ChaincodeId: "bank",
+		TxId: "tx",
+		EventName: "referralsChanged",
+		Payload: json.RawMessage(`{"schemaVersion":2}`),
+	}
+}
+
+func TestDeliveriesAreSigned(t *testing.T) {
+	server := &endpointServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	endpoint := relay.Endpoint{Name: "crm", URL: ts.URL, Secret: "s3cret"}
+	err := newDeliverer().Deliver(context.Background(), endpoint, testEvent(7, 1))
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	if len(server.requests) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(server.requests))
+	}
+
+	r, body := server.requests[0], server.bodies[0]
+	if r.Header.Get(relay.HeaderEventId) != "7-1" || r.Header.Get(relay.HeaderEventName) != "referralsChanged" {
+		t.Errorf("got event headers %q %q", r.Header.Get(relay.HeaderEventId), r.Header.Get(relay.HeaderEventName))
+	}
+
+	if r.Header.Get(relay.HeaderTimestamp) != "1700000000" {
+		t.Errorf("got timestamp %q", r.Header.Get(relay.HeaderTimestamp))
+	}
+
+	want := relay.Sign("s3cret", "1700000000", body)
+	if r.Header.Get(relay.HeaderSignature) != want {
+		t.Errorf("got signature %q, want %q", r.Header.Get(relay.HeaderSignature), want)
+	}
+
+	if relay.Sign("other", "1700000000", body) == want {
+		t.Errorf("the signature does not depend on the secret")
+	}
+}
+
+func TestDeliveriesAreRetriedUntilAccepted(t *testing.T) {
+	server := &endpointServer{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	endpoint := relay.Endpoint{Name: "crm", URL: ts.URL}
+	err := newDeliverer().Deliver(context.Background(), endpoint, testEvent(1, 0))
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	if len(server.requests) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(server.requests))
+	}
+
+	for _, r := range server.requests {
+		if r.Header.Get(relay.HeaderEventId) != "1-0" {
+			t.Errorf("a retry was sent as event %q", r.Header.Get(relay.HeaderEventId))
+		}
+	}
+}
+
+func TestRejectedDeliveriesAreNotRetried(t *testing.T) {
+	server := &endpointServer{statuses: []int{http.StatusBadRequest}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	endpoint := relay.Endpoint{Name: "crm", URL: ts.URL}
+	err := newDeliverer().Deliver(context.Background(), endpoint, testEvent(1, 0))
+	if !errors.Is(err, relay.ErrRejected) {
+		t.Fatalf("got %v, want ErrRejected", err)
+	}
+
+	if len(server.requests) != 1 {
+		t.Errorf("got %d attempts, want 1", len(server.requests))
+	}
+}
+
+// sliceSource streams a fixed list of events and then waits, as a peer does at the chain's height
+type sliceSource struct {
+	events []relay.Event
+}
+
+func (s sliceSource) Events(ctx context.Context, fromBlock uint64, handle func(event relay.Event) error) error {
+	for _, event := range s.events {
+		if event.BlockNumber < fromBlock {
+			continue
+		}
+
+		err := handle(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// runRelay runs the relay until the crm endpoint's cursor reaches the wanted position
+func runRelay(t *testing.T, r *relay.Relay, want relay.Position) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+
+	cursor := relay.FileCursor{Path: filepath.Join(r.StateDir, "crm.cursor")}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		position, err := cursor.Load()
+		if err == nil && position == want {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRelayResumesAfterTheLastDeliveredEvent(t *testing.T) {
+	server := &endpointServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	r := &relay.Relay{
+		Source: sliceSource{events: []relay.Event{testEvent(3, 0), testEvent(3, 1)}},
+		Endpoints: []relay.Endpoint{{Name: "crm", URL: ts.URL}},
+		Deliverer: newDeliverer(),
+		StateDir: t.TempDir(),
+		RetryInterval: time.Millisecond,
+	}
+	runRelay(t, r, relay.Position{Block: 3, Index: 2})
+
+	r.Source = sliceSource{events: []relay.Event{testEvent(3, 0), testEvent(3, 1), testEvent(3, 2), testEvent(5, 0)}}
+	runRelay(t, r, relay.Position{Block: 5, Index: 1})
+
+	var ids []string
+	for _, r := range server.requests {
+		ids = append(ids, r.Header.Get(relay.HeaderEventId))
+	}
+
+	want := []string{"3-0", "3-1", "3-2", "5-0"}
+	if len(ids) != len(want) {
+		t.Fatalf("got deliveries %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("got deliveries %v, want %v", ids, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/joerust/referral-partners/peerclient"
+)
+
+// Source streams chaincode events from the chain
+type Source interface {
+	// Events passes handle the events committed from block fromBlock on, in the order they were
+	// committed, and keeps waiting for new blocks. It returns when the context is cancelled, the stream
+	// fails or handle returns an error
+	Events(ctx context.Context, fromBlock uint64, handle func(event Event) error) error
+}
+
+// PeerSource streams the events of one chaincode through a peer's Fabric Gateway service. An event's
+// index is its position among the chaincode's events in the block
+type PeerSource struct {
+	Client *peerclient.Client
+	ChaincodeName string
+}
+
+func NewPeerSource(client *peerclient.Client, chaincodeName string) *PeerSource {
+	return &PeerSource{
+		Client: client,
+		ChaincodeName: chaincodeName,
+	}
+}
+
+func (s *PeerSource) Events(ctx context.Context, fromBlock uint64, handle func(event Event) error) error {
+	return s.Client.ChaincodeEvents(ctx, s.ChaincodeName, fromBlock, func(blockNumber uint64, events []*peer.ChaincodeEvent) error {
+		for i, chaincodeEvent := range events {
+			err := handle(Event{
+				BlockNumber: blockNumber,
+				EventIndex: i,
+				ChaincodeId: chaincodeEvent.ChaincodeId,
+				TxId: chaincodeEvent.TxId,
+				EventName: chaincodeEvent.EventName,
+				Payload: jsonPayload(chaincodeEvent.Payload),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Chaincode payloads are normally JSON and are passed through as is. Anything else is sent as a JSON string
+func jsonPayload(payload []byte) json.RawMessage {
+	if json.Valid(payload) {
+		return json.RawMessage(payload)
+	}
+
+	quoted, _ := json.Marshal(string(payload))
+	return json.RawMessage(quoted)
+}
\ No newline at end of file
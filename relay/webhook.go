@@ -0,0 +1,153 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The headers sent with every delivery. The signature is "sha256=" followed by the hex HMAC-SHA256,
+// keyed with the endpoint secret, of the timestamp header, a period and the request body
+const (
+	HeaderEventId = "X-Referral-Event-Id"
+	HeaderEventName = "X-Referral-Event-Name"
+	HeaderTimestamp = "X-Referral-Timestamp"
+	HeaderSignature = "X-Referral-Signature"
+)
+
+// Endpoint is a webhook events are posted to
+type Endpoint struct {
+	Name string `json:"name"`
+	URL string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// ErrRejected is returned when an endpoint refuses an event in a way retrying will not fix
+var ErrRejected = errors.New("event rejected by endpoint")
+
+// Backoff is an exponential retry delay that starts at Initial and doubles up to Max
+type Backoff struct {
+	Initial time.Duration
+	Max time.Duration
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 0; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > b.Max {
+		delay = b.Max
+	}
+
+	return delay
+}
+
+// Sign returns the signature header value for a body sent at the given timestamp
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliverer posts events to endpoints
+type Deliverer struct {
+	Client *http.Client
+	Backoff Backoff
+	Now func() time.Time
+}
+
+func NewDeliverer() *Deliverer {
+	return &Deliverer{
+		Client: &http.Client{Timeout: 30 * time.Second},
+		Backoff: Backoff{Initial: time.Second, Max: 5 * time.Minute},
+		Now: time.Now,
+	}
+}
+
+// Deliver posts the event until the endpoint accepts it, backing off between attempts. It gives up only
+// when the context is cancelled or the endpoint rejects the event with ErrRejected
+func (d *Deliverer) Deliver(ctx context.Context, endpoint Endpoint, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = d.post(ctx, endpoint, event, body)
+		if err == nil || errors.Is(err, ErrRejected) {
+			return err
+		}
+
+		delay := d.Backoff.delay(attempt)
+		logf("%s: delivering event %s failed, retrying in %s: %v", endpoint.Name, event.Id(), delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (d *Deliverer) post(ctx context.Context, endpoint Endpoint, event Event, body []byte) error {
+	timestamp := strconv.FormatInt(d.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventId, event.Id())
+	req.Header.Set(HeaderEventName, event.EventName)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if endpoint.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, body))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64 * 1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	// Timeouts, throttling and server errors are worth retrying, other client errors are not
+	if resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("%s answered %s", endpoint.URL, resp.Status)
+	}
+
+	return fmt.Errorf("%w: %s answered %s", ErrRejected, endpoint.URL, resp.Status)
+}
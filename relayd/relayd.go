@@ -0,0 +1,135 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// relayd relays the referral chaincode events to HTTP webhooks. It streams the events of one chaincode
+// from a peer's Fabric Gateway service, as the identity in cert and key, and is configured with a JSON
+// file:
+//
+//	{
+//		"peer": "localhost:7051",
+//		"tlsCert": "<CA certificate of the peer's TLS certificate>",
+//		"mspId": "BankMSP",
+//		"cert": "<certificate of the identity to read events as>",
+//		"key": "<private key of the identity>",
+//		"channel": "referrals",
+//		"chaincode": "<bank or partner chaincode name>",
+//		"retryInterval": "5s",
+//		"stateDir": "/var/lib/relayd",
+//		"endpoints": [{"name": "crm", "url": "https://crm.example.com/hooks/referrals", "secret": "..."}]
+//	}
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"github.com/joerust/referral-partners/peerclient"
+	"github.com/joerust/referral-partners/relay"
+)
+
+type config struct {
+	Peer string `json:"peer"`
+	TLSCert string `json:"tlsCert"`
+	ServerName string `json:"serverName"`
+	MspId string `json:"mspId"`
+	Cert string `json:"cert"`
+	Key string `json:"key"`
+	Channel string `json:"channel"`
+	Chaincode string `json:"chaincode"`
+	RetryInterval string `json:"retryInterval"`
+	StateDir string `json:"stateDir"`
+	MaxBackoff string `json:"maxBackoff"`
+	Endpoints []relay.Endpoint `json:"endpoints"`
+}
+
+func loadConfig(path string) (config, error) {
+	c := config{RetryInterval: "5s", StateDir: ".", MaxBackoff: "5m"}
+
+	valAsbytes, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(valAsbytes, &c)
+	return c, err
+}
+
+func main() {
+	configPath := flag.String("config", "relayd.json", "path to the relay configuration")
+	flag.Parse()
+
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Error reading configuration: %s", err)
+	}
+
+	retryInterval, err := time.ParseDuration(c.RetryInterval)
+	if err != nil {
+		log.Fatalf("Invalid retryInterval: %s", err)
+	}
+
+	maxBackoff, err := time.ParseDuration(c.MaxBackoff)
+	if err != nil {
+		log.Fatalf("Invalid maxBackoff: %s", err)
+	}
+
+	if c.Chaincode == "" || len(c.Endpoints) == 0 {
+		log.Fatalf("The configuration needs a chaincode and at least one endpoint")
+	}
+
+	for _, endpoint := range c.Endpoints {
+		if endpoint.Name == "" || endpoint.URL == "" {
+			log.Fatalf("Every endpoint needs a name and a url")
+		}
+	}
+
+	client, err := peerclient.Connect(peerclient.Config{
+		Endpoint: c.Peer,
+		TLSCertPath: c.TLSCert,
+		ServerName: c.ServerName,
+		MspId: c.MspId,
+		CertPath: c.Cert,
+		KeyPath: c.Key,
+		Channel: c.Channel,
+	})
+	if err != nil {
+		log.Fatalf("Error connecting to the peer: %s", err)
+	}
+	defer client.Close()
+
+	deliverer := relay.NewDeliverer()
+	deliverer.Backoff.Max = maxBackoff
+
+	r := &relay.Relay{
+		Source: relay.NewPeerSource(client, c.Chaincode),
+		Endpoints: c.Endpoints,
+		Deliverer: deliverer,
+		StateDir: c.StateDir,
+		RetryInterval: retryInterval,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Relaying events from %s to %d endpoints", c.Peer, len(c.Endpoints))
+	err = r.Run(ctx)
+	if err != nil && err != context.Canceled {
+		log.Fatalf("Error running relay: %s", err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+)
+
+// The certificate extension Fabric CA stores enrollment attributes in
+var attributesExtension = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Identity is a caller transactions can be submitted as. Its certificate carries attributes the way
+// Fabric CA enrolls them, so chaincodes read it with the cid package as they would on a peer
+type Identity struct {
+	MspId string
+	CommonName string
+	Attributes map[string]string
+	creator []byte
+}
+
+// NewIdentity creates an identity with a self-signed certificate
+func NewIdentity(mspId string, commonName string, attributes map[string]string) (*Identity, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	attributesAsBytes, err := json.Marshal(map[string]map[string]string{"attrs": attributes})
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{CommonName: commonName, Organization: []string{mspId}},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(365 * 24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: attributesExtension, Value: attributesAsBytes}},
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid: mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{MspId: mspId, CommonName: commonName, Attributes: attributes, creator: creator}, nil
+}
+
+// Creator is the serialized identity a peer hands the chaincode as the transaction creator
+func (i *Identity) Creator() []byte {
+	return i.creator
+}
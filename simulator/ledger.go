@@ -0,0 +1,281 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+// Package simulator runs chaincodes against an in-memory world state, without a peer. Several chaincodes
+// can be registered with one Ledger and call each other through InvokeChaincode, so the bank and partner
+// chaincodes can be driven together from tests and local tools.
+package simulator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// DefaultChannelId is the channel a new Ledger reports to its chaincodes
+const DefaultChannelId = "referrals"
+
+// Event is the chaincode event of a committed transaction
+type Event struct {
+	ChaincodeName string `json:"chaincodeName"`
+	TxId string `json:"txId"`
+	EventName string `json:"eventName"`
+	Payload []byte `json:"payload"`
+}
+
+// Ledger holds the world state of every chaincode registered with it. Each chaincode sees only its own
+// keys, as it would on a peer
+type Ledger struct {
+	ChannelId string
+	chaincodes map[string]shim.Chaincode
+	state map[string]map[string][]byte
+	events []Event
+	txCount int
+	lastTxId string
+	now time.Time
+	caller *Identity
+	transient map[string][]byte
+}
+
+// NewLedger returns an empty ledger whose transactions are stamped with the current time
+func NewLedger() *Ledger {
+	return &Ledger{
+		ChannelId: DefaultChannelId,
+		chaincodes: map[string]shim.Chaincode{},
+		state: map[string]map[string][]byte{},
+	}
+}
+
+// Register installs a chaincode under the name other chaincodes and callers use to reach it
+func (l *Ledger) Register(chaincodeName string, chaincode shim.Chaincode) {
+	l.chaincodes[chaincodeName] = chaincode
+	if l.state[chaincodeName] == nil {
+		l.state[chaincodeName] = map[string][]byte{}
+	}
+}
+
+// SetCaller sets the identity the following transactions are submitted as. Without one the
+// transactions have no creator
+func (l *Ledger) SetCaller(caller *Identity) {
+	l.caller = caller
+}
+
+// SetTransient sets the transient data passed with the following transactions. Chaincodes called from
+// another chaincode see the same transient data
+func (l *Ledger) SetTransient(transient map[string][]byte) {
+	l.transient = transient
+}
+
+// SetTime fixes the timestamp of the following transactions
+func (l *Ledger) SetTime(now time.Time) {
+	l.now = now
+}
+
+// Advance moves the transaction time forward, fixing it if it was following the clock
+func (l *Ledger) Advance(d time.Duration) {
+	l.now = l.Now().Add(d)
+}
+
+// Now is the timestamp the next transaction will get
+func (l *Ledger) Now() time.Time {
+	if l.now.IsZero() {
+		return time.Now()
+	}
+
+	return l.now
+}
+
+// Init runs a chaincode's Init in a transaction, committing its writes if it succeeds
+func (l *Ledger) Init(chaincodeName string, args ...string) pb.Response {
+	return l.execute(chaincodeName, true, args, true)
+}
+
+// Invoke runs a chaincode function in a transaction, committing its writes if it succeeds
+func (l *Ledger) Invoke(chaincodeName string, args ...string) pb.Response {
+	return l.execute(chaincodeName, false, args, true)
+}
+
+// Query runs a chaincode function and discards its writes, like a peer evaluating a proposal
+// that is never submitted
+func (l *Ledger) Query(chaincodeName string, args ...string) pb.Response {
+	return l.execute(chaincodeName, false, args, false)
+}
+
+// LastTxId returns the id of the last transaction run on the ledger
+func (l *Ledger) LastTxId() string {
+	return l.lastTxId
+}
+
+// GetState returns a committed value, or nil if the chaincode has not written the key
+func (l *Ledger) GetState(chaincodeName string, key string) []byte {
+	return l.state[chaincodeName][key]
+}
+
+// Keys returns the committed keys of a chaincode in sorted order
+func (l *Ledger) Keys(chaincodeName string) []string {
+	var keys []string
+	for key := range l.state[chaincodeName] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Snapshot is the committed state of a ledger, and how many transactions it has run, so it can be saved
+// and picked up again in a later run without reusing transaction ids. Events are not kept
+type Snapshot struct {
+	State map[string]map[string][]byte `json:"state"`
+	TxCount int `json:"txCount"`
+}
+
+// Snapshot returns a copy of the ledger's committed state
+func (l *Ledger) Snapshot() Snapshot {
+	snapshot := Snapshot{State: map[string]map[string][]byte{}, TxCount: l.txCount}
+	for chaincodeName, state := range l.state {
+		snapshot.State[chaincodeName] = map[string][]byte{}
+		for key, value := range state {
+			snapshot.State[chaincodeName][key] = value
+		}
+	}
+
+	return snapshot
+}
+
+// Restore replaces the committed state of the registered chaincodes with a snapshot's
+func (l *Ledger) Restore(snapshot Snapshot) {
+	l.txCount = snapshot.TxCount
+	for chaincodeName := range l.state {
+		l.state[chaincodeName] = map[string][]byte{}
+		for key, value := range snapshot.State[chaincodeName] {
+			l.state[chaincodeName][key] = value
+		}
+	}
+}
+
+// Events returns the events of the committed transactions in the order they committed. A transaction
+// has at most one event, set by the chaincode it was submitted to
+func (l *Ledger) Events() []Event {
+	return l.events
+}
+
+func (l *Ledger) execute(chaincodeName string, init bool, args []string, commit bool) pb.Response {
+	l.txCount++
+	txHash := sha256.Sum256([]byte(l.ChannelId + "/" + strconv.Itoa(l.txCount)))
+
+	tx := &transaction{
+		ledger: l,
+		txId: hex.EncodeToString(txHash[:]),
+		timestamp: l.Now(),
+		chaincodeName: chaincodeName,
+		writes: map[string]map[string]write{},
+		running: map[string]bool{},
+	}
+
+	l.lastTxId = tx.txId
+
+	if l.caller != nil {
+		tx.creator = l.caller.Creator()
+	}
+
+	tx.transient = map[string][]byte{}
+	for key, value := range l.transient {
+		tx.transient[key] = value
+	}
+
+	for _, arg := range args {
+		tx.args = append(tx.args, []byte(arg))
+	}
+
+	response := tx.call(chaincodeName, init, tx.args, false)
+	if commit && response.Status < shim.ERRORTHRESHOLD {
+		tx.commit()
+	}
+
+	return response
+}
+
+// write is a pending PutState or DelState
+type write struct {
+	value []byte
+	deleted bool
+}
+
+// transaction collects the writes and the event of one invocation, including the writes of the
+// chaincodes it calls. Nothing reaches the ledger until the transaction commits, and reads always see
+// committed state, as they do on a peer. chaincodeName and args are what the transaction was submitted
+// with, and running holds the chaincodes currently executing in it
+type transaction struct {
+	ledger *Ledger
+	txId string
+	timestamp time.Time
+	chaincodeName string
+	args [][]byte
+	creator []byte
+	transient map[string][]byte
+	writes map[string]map[string]write
+	event *Event
+	running map[string]bool
+}
+
+// call runs a chaincode as part of the transaction. A peer refuses to run a chaincode that is already
+// executing in the transaction, so a chaincode cannot be called back by a chaincode it called
+func (tx *transaction) call(chaincodeName string, init bool, args [][]byte, called bool) pb.Response {
+	chaincode, found := tx.ledger.chaincodes[chaincodeName]
+	if !found {
+		return shim.Error("Chaincode " + chaincodeName + " is not registered")
+	}
+
+	if tx.running[chaincodeName] {
+		return shim.Error("Chaincode " + chaincodeName + " is already running in transaction " + tx.txId)
+	}
+
+	tx.running[chaincodeName] = true
+	defer delete(tx.running, chaincodeName)
+
+	stub := &Stub{tx: tx, chaincodeName: chaincodeName, args: args, called: called}
+	if init {
+		return chaincode.Init(stub)
+	}
+
+	return chaincode.Invoke(stub)
+}
+
+func (tx *transaction) put(chaincodeName string, key string, w write) {
+	if tx.writes[chaincodeName] == nil {
+		tx.writes[chaincodeName] = map[string]write{}
+	}
+	tx.writes[chaincodeName][key] = w
+}
+
+func (tx *transaction) commit() {
+	for chaincodeName, writes := range tx.writes {
+		state := tx.ledger.state[chaincodeName]
+		for key, w := range writes {
+			if w.deleted {
+				delete(state, key)
+			} else {
+				state[key] = w.value
+			}
+		}
+	}
+
+	if tx.event != nil {
+		tx.ledger.events = append(tx.ledger.events, *tx.event)
+	}
+}
@@ -0,0 +1,676 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+
+package simulator_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/joerust/referral-partners/bank"
+	"github.com/joerust/referral-partners/partnerlogic"
+	"github.com/joerust/referral-partners/simulator"
+)
+
+// The bank's key for the PII it stores and the salt for customer hashes, passed in transient data as the
+// bank's gateway does
+var bankPIIKey = map[string][]byte{"piiKey.Bank": []byte("0123456789abcdef0123456789abcdef"), bank.DuplicateSaltTransientName: []byte("pepper")}
+
+// The customer name and contact number of a referral, passed in transient data as the gateway does
+var annLee = map[string][]byte{partnerlogic.PIITransientName: []byte(`{"customerName":"Ann Lee","contactNumber":"+1 555 123 4567"}`)}
+
+// network is a ledger running the bank chaincode and the Vantiv partner chaincode, with Vantiv
+// onboarded for the Vantiv department
+type network struct {
+	t *testing.T
+	ledger *simulator.Ledger
+	manager *simulator.Identity
+	employee *simulator.Identity
+	vantiv *simulator.Identity
+}
+
+func newNetwork(t *testing.T) *network {
+	n := &network{t: t, ledger: simulator.NewLedger()}
+	n.ledger.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	n.ledger.Register("bank", new(bank.PartnerChaincode))
+	n.ledger.Register("vantiv", partnerlogic.NewPartnerChaincode(partnerlogic.VantivAdapter{}))
+
+	n.manager = n.identity("bank.manager", "", "m1")
+	n.employee = n.identity("bank.employee", "", "e1")
+	n.vantiv = n.identity("partner.admin", "Vantiv", "")
+
+	n.expect(n.ledger.Init("bank", "init", `{"partnerName":"Bank","duplicateSaltHash":"8cbbcf29d9cef89675c5f5c1dcfe827d0570416a5aaba30dd0de159661ad905b"}`), shim.OK)
+	n.expect(n.ledger.Init("vantiv", "init", `{"bankChaincodeName":"bank","piiKey":"VkFOVElWVkFOVElWVkFOVElWVkFOVElWVkFOVElWMTI=","development":true}`), shim.OK)
+	n.invoke(n.manager, "bank", "onboardPartner", `{"partnerName":"Vantiv","chaincodeName":"vantiv","departments":["Vantiv"]}`)
+
+	return n
+}
+
+func (n *network) identity(role string, partner string, employeeId string) *simulator.Identity {
+	attributes := map[string]string{partnerlogic.AttrRole: role}
+	if partner != "" {
+		attributes[partnerlogic.AttrPartner] = partner
+	}
+	if employeeId != "" {
+		attributes[partnerlogic.AttrEmployeeId] = employeeId
+	}
+
+	identity, err := simulator.NewIdentity("BankMSP", role + partner + employeeId, attributes)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+
+	return identity
+}
+
+func (n *network) expect(response pb.Response, status int32) {
+	n.t.Helper()
+
+	if response.Status != status {
+		n.t.Fatalf("expected status %d, got %d: %s", status, response.Status, response.Message)
+	}
+}
+
+// submit submits a transaction as the caller with the bank's key and the given transient data
+func (n *network) submit(caller *simulator.Identity, transient map[string][]byte, chaincodeName string, args ...string) pb.Response {
+	merged := map[string][]byte{}
+	for _, fields := range []map[string][]byte{bankPIIKey, transient} {
+		for name, value := range fields {
+			merged[name] = value
+		}
+	}
+
+	n.ledger.SetCaller(caller)
+	n.ledger.SetTransient(merged)
+	return n.ledger.Invoke(chaincodeName, args...)
+}
+
+// invoke submits a transaction as the caller and fails the test unless it succeeds
+func (n *network) invoke(caller *simulator.Identity, chaincodeName string, args ...string) []byte {
+	n.t.Helper()
+
+	response := n.submit(caller, nil, chaincodeName, args...)
+	n.expect(response, shim.OK)
+
+	return response.Payload
+}
+
+// query evaluates a query as the caller and fails the test unless it succeeds
+func (n *network) query(caller *simulator.Identity, chaincodeName string, args ...string) []byte {
+	n.t.Helper()
+
+	n.ledger.SetCaller(caller)
+	n.ledger.SetTransient(bankPIIKey)
+	response := n.ledger.Query(chaincodeName, args...)
+	n.expect(response, shim.OK)
+
+	return response.Payload
+}
+
+// createReferral has the employee refer a customer to Vantiv
+func (n *network) createReferral(referralId string, customerId string) {
+	n.t.Helper()
+
+	n.createCustomerReferral(referralId, customerId, "+1 555 123 4567")
+}
+
+// createCustomerReferral refers a customer to Vantiv under a contact number. Referrals with different
+// numbers are not duplicates of each other, so each is forwarded
+func (n *network) createCustomerReferral(referralId string, customerId string, contactNumber string) {
+	n.t.Helper()
+
+	pii := map[string][]byte{partnerlogic.PIITransientName: []byte(`{"customerName":"Ann Lee","contactNumber":"` + contactNumber + `"}`)}
+	n.expect(n.submit(n.employee, pii, "bank", "createReferral", referralId, `{"referralId":"` + referralId + `","customerId":"` + customerId + `","employeeId":"e1","departments":["Vantiv"],"consent":{"scope":["Vantiv"],"channel":"BRANCH","timestamp":1,"capturedBy":"e1"}}`), shim.OK)
+}
+
+func statusIndex(l *simulator.Ledger, chaincodeName string, status string) []string {
+	return partnerlogic.SplitReferralIds(string(l.GetState(chaincodeName, status)))
+}
+
+func TestReclosingKeepsTheReferralIndexed(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+
+	n.ledger.Advance(time.Hour)
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "MID")
+
+	// Closing again with other criteria moves the referral from CLOSED to CLOSED
+	n.ledger.Advance(time.Hour)
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "SMALL")
+
+	if closed := statusIndex(n.ledger, "vantiv", "CLOSED"); len(closed) != 1 || closed[0] != "r1" {
+		t.Fatalf("expected r1 to be indexed once as CLOSED, got %v", closed)
+	}
+
+	var referrals []partnerlogic.PartnerReferral
+	err := json.Unmarshal(n.query(n.vantiv, "vantiv", "searchByStatus", "CLOSED"), &referrals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(referrals) != 1 || referrals[0].DealCriteria != "SMALL" {
+		t.Fatalf("expected r1 closed as SMALL, got %+v", referrals)
+	}
+
+	// The second closing reverses the first in the statement of the month
+	var statement partnerlogic.Statement
+	err = json.Unmarshal(n.query(n.vantiv, "vantiv", "readStatement", "2026-01"), &statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statement.ClosedDeals) != 2 || len(statement.Reversals) != 1 {
+		t.Fatalf("expected two closings and one reversal, got %+v", statement)
+	}
+}
+
+func TestMaskingKeepsTheStatementBytes(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "MID")
+
+	auditor := n.identity("auditor", "", "")
+	unmasked := n.query(n.vantiv, "vantiv", "readStatement", "2026-01")
+	masked := n.query(auditor, "vantiv", "readStatement", "2026-01")
+
+	if string(masked) != string(unmasked) {
+		t.Fatalf("expected the auditor to read the same statement bytes\n%s\n%s", unmasked, masked)
+	}
+
+	referral := string(n.invoke(auditor, "vantiv", "auditedRead", "r1"))
+	if !strings.Contains(referral, `"customerName":"A. L."`) || !strings.Contains(referral, `"compensation":1750,`) {
+		t.Fatalf("expected only the name and contact number masked, got %s", referral)
+	}
+}
+
+func TestAnalystsSeeNoContactNumber(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+
+	var referral map[string]interface{}
+	err := json.Unmarshal(n.invoke(n.identity("analyst", "", ""), "bank", "auditedRead", "r1"), &referral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if referral["customerName"] != "A. L." {
+		t.Fatalf("expected the analyst to see initials, got %v", referral["customerName"])
+	}
+
+	if _, found := referral["contactNumber"]; found {
+		t.Fatalf("expected the analyst not to see the contact number, got %v", referral["contactNumber"])
+	}
+}
+
+func TestStatsCountEachReferralOnceInItsCreationDay(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+
+	// The deal closes the next day, is reopened and closed again
+	n.ledger.Advance(26 * time.Hour)
+	n.invoke(n.vantiv, "vantiv", "updateReferralStatus", "r1", "PENDING")
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "MID")
+	n.invoke(n.vantiv, "vantiv", "updateReferralStatus", "r1", "PENDING")
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "SMALL")
+
+	var report partnerlogic.StatsReport
+	err := json.Unmarshal(n.query(n.vantiv, "vantiv", "referralStats", "partner", "2026-01-01", "2026-01-01"), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Groups) != 1 {
+		t.Fatalf("expected the Vantiv funnel, got %+v", report)
+	}
+
+	funnel := report.Groups[0]
+	for _, stat := range []string{partnerlogic.StatCreated, partnerlogic.StatAccepted, "PENDING", "CLOSED"} {
+		if funnel.Counts[stat] != 1 {
+			t.Errorf("expected %s to be counted once, got %d", stat, funnel.Counts[stat])
+		}
+	}
+
+	if funnel.AcceptanceRate != 1 || funnel.CloseRate != 1 || funnel.AcceptedCloseRate != 1 {
+		t.Errorf("expected every rate to be 1, got %+v", funnel)
+	}
+}
+
+func TestLeaderboardCountsTheLatestClosingOfEachPartner(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+
+	n.ledger.Advance(26 * time.Hour)
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "MID")
+	n.invoke(n.manager, "bank", "syncPartnerStatus", "Vantiv", "r1")
+
+	// The deal is reopened in February and closed again, for less, in March
+	n.ledger.SetTime(time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC))
+	n.invoke(n.vantiv, "vantiv", "updateReferralStatus", "r1", "ACTIVE")
+	n.ledger.SetTime(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC))
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "SMALL")
+	n.invoke(n.manager, "bank", "syncPartnerStatus", "Vantiv", "r1")
+
+	for _, test := range []struct {
+		from, to string
+		closed, compensation int64
+	}{
+		{"2026-01-01", "2026-01-31", 0, 0},
+		{"2026-03-01", "2026-03-31", 1, 400},
+	} {
+		var board bank.Leaderboard
+		err := json.Unmarshal(n.query(n.manager, "bank", "leaderboard", test.from, test.to), &board)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var closed, compensation int64
+		for _, entry := range board.ByDealsClosed {
+			closed += entry.Closed
+			compensation += entry.Compensation
+		}
+
+		if closed != test.closed || compensation != test.compensation {
+			t.Errorf("%s to %s: expected %d deals for %d, got %d for %d", test.from, test.to, test.closed, test.compensation, closed, compensation)
+		}
+	}
+}
+
+
+func TestAccessReportsFindPartnerReadsByCustomer(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+
+	n.invoke(n.manager, "bank", "auditedRead", "r1")
+	n.invoke(n.vantiv, "vantiv", "auditedRead", "r1")
+
+	for _, read := range []struct {
+		caller *simulator.Identity
+		chaincodeName string
+	}{
+		{n.manager, "bank"},
+		{n.vantiv, "vantiv"},
+	} {
+		var accesses []partnerlogic.AuditEntry
+		err := json.Unmarshal(n.query(read.caller, read.chaincodeName, "accessReport", "customer", "c1"), &accesses)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(accesses) != 1 || accesses[0].Subject != "c1" || accesses[0].ReferralIds[0] != "r1" {
+			t.Fatalf("expected the %s read of r1 reported under c1, got %+v", read.chaincodeName, accesses)
+		}
+	}
+}
+
+
+
+func TestRevokingConsentDeclinesEveryOpenReferralInTheIndexes(t *testing.T) {
+	n := newNetwork(t)
+	n.createCustomerReferral("r1", "c1", "+1 555 123 4567")
+	n.createCustomerReferral("r2", "c1", "+1 555 765 4321")
+	n.createCustomerReferral("r3", "c2", "+1 555 000 1111")
+
+	n.invoke(n.employee, "bank", "revokeConsent", "c1")
+
+	for _, chaincodeName := range []string{"bank", "vantiv"} {
+		if active := statusIndex(n.ledger, chaincodeName, "ACTIVE"); len(active) != 1 || active[0] != "r3" {
+			t.Fatalf("expected only r3 left ACTIVE on %s, got %v", chaincodeName, active)
+		}
+
+		if declined := statusIndex(n.ledger, chaincodeName, "DECLINED"); len(declined) != 2 || declined[0] != "r1" || declined[1] != "r2" {
+			t.Fatalf("expected r1 and r2 DECLINED on %s, got %v", chaincodeName, declined)
+		}
+	}
+}
+
+
+func TestBankRolesReachPartnersOnlyThroughTheBankChaincode(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+
+	for _, call := range []struct {
+		caller *simulator.Identity
+		args []string
+	}{
+		{n.employee, []string{"createReferral", "r2", `{"referralId":"r2","customerName":"Ann Lee","contactNumber":"+1 555 123 4567","departments":["Vantiv"]}`}},
+		{n.employee, []string{"revokeConsent", "r1"}},
+		{n.manager, []string{"redactReferral", "r1"}},
+	} {
+		n.expect(n.submit(call.caller, nil, "vantiv", call.args...), 403)
+	}
+
+	if active := statusIndex(n.ledger, "vantiv", "ACTIVE"); len(active) != 1 || active[0] != "r1" {
+		t.Fatalf("expected only r1 ACTIVE on vantiv, got %v", active)
+	}
+}
+
+
+func TestEmployeesMakeReferralsOnlyInTheirOwnName(t *testing.T) {
+	n := newNetwork(t)
+
+	n.expect(n.submit(n.employee, annLee, "bank", "createReferral", "r1", `{"referralId":"r1","customerId":"c1","employeeId":"e2","departments":["Vantiv"],"consent":{"scope":["Vantiv"],"channel":"BRANCH","timestamp":1,"capturedBy":"e1"}}`), 403)
+
+	n.expect(n.submit(n.employee, annLee, "bank", "createReferral", "r1", `{"referralId":"r1","customerId":"c1","departments":["Vantiv"],"consent":{"scope":["Vantiv"],"channel":"BRANCH","timestamp":1,"capturedBy":"e1"}}`), shim.OK)
+
+	var referral partnerlogic.CustomerReferral
+	err := json.Unmarshal(n.query(n.manager, "bank", "read", "r1"), &referral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if referral.EmployeeId != "e1" {
+		t.Fatalf("expected the referral attributed to e1, got %q", referral.EmployeeId)
+	}
+}
+
+
+func TestPIIIsSealedAndOnlyPassedInTransientData(t *testing.T) {
+	n := newNetwork(t)
+
+	response := n.submit(n.employee, nil, "bank", "createReferral", "r1", `{"referralId":"r1","customerName":"Ann Lee","contactNumber":"+1 555 123 4567","customerId":"c1","departments":["Vantiv"],"consent":{"scope":["Vantiv"],"channel":"BRANCH","timestamp":1,"capturedBy":"e1"}}`)
+	n.expect(response, 400)
+
+	n.createReferral("r1", "c1")
+
+	for _, chaincodeName := range []string{"bank", "vantiv"} {
+		if state := string(n.ledger.GetState(chaincodeName, "r1")); state == "" || strings.Contains(state, "Ann Lee") || strings.Contains(state, "555") {
+			t.Fatalf("expected the PII sealed in the %s state, got %s", chaincodeName, state)
+		}
+	}
+
+	var referral partnerlogic.CustomerReferral
+	err := json.Unmarshal(n.invoke(n.manager, "bank", "auditedRead", "r1"), &referral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if referral.CustomerName != "Ann Lee" || referral.ContactNumber != "+1 555 123 4567" || referral.PII != nil {
+		t.Fatalf("expected the bank's key to reveal the PII, got %+v", referral)
+	}
+
+	n.ledger.SetCaller(n.vantiv)
+	n.expect(n.ledger.Init("vantiv", "init", `{"bankChaincodeName":"bank","piiKey":"VkFOVElWVkFOVElWVkFOVElWVkFOVElWVkFOVElWMTI="}`), 400)
+}
+
+
+func TestRedactingACustomerRemovesEachReferralFromTheIndexes(t *testing.T) {
+	n := newNetwork(t)
+	n.createCustomerReferral("r1", "c1", "+1 555 123 4567")
+	n.createCustomerReferral("r2", "c1", "+1 555 123 4567")
+	n.createCustomerReferral("r3", "c2", "+1 555 123 4567")
+	n.createCustomerReferral("r4", "c1", "+1 555 765 4321")
+
+	var duplicates []bank.DuplicateGroup
+	err := json.Unmarshal(n.query(n.manager, "bank", "findDuplicateReferrals"), &duplicates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(duplicates) != 1 || len(duplicates[0].ReferralIds) != 3 {
+		t.Fatalf("expected r1, r2 and r3 grouped as one customer, got %+v", duplicates)
+	}
+	hash := duplicates[0].CustomerHash
+
+	n.invoke(n.manager, "bank", "redactCustomer", "c1")
+
+	if remaining := partnerlogic.SplitReferralIds(string(n.ledger.GetState("bank", "CUSTOMER_" + hash))); len(remaining) != 1 || remaining[0] != "r3" {
+		t.Fatalf("expected only r3 left for the customer hash, got %v", remaining)
+	}
+
+	err = json.Unmarshal(n.query(n.manager, "bank", "findDuplicateReferrals"), &duplicates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(duplicates) != 0 {
+		t.Fatalf("expected no duplicates left, got %+v", duplicates)
+	}
+
+	// r1 and r4 were forwarded to Vantiv, r2 was a duplicate of r1
+	var entries []partnerlogic.AuditEntry
+	err = json.Unmarshal(n.query(n.vantiv, "vantiv", "readAuditLog"), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].ReferralIds[0] == entries[1].ReferralIds[0] {
+		t.Fatalf("expected an audit entry for each redacted Vantiv referral, got %+v", entries)
+	}
+}
+
+
+
+func TestOnlyAuditedReadsRevealPII(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+	n.invoke(n.manager, "bank", "auditedRead", "r1")
+
+	for _, query := range []struct {
+		caller *simulator.Identity
+		chaincodeName string
+		args []string
+	}{
+		{n.manager, "bank", []string{"read", "r1"}},
+		{n.manager, "bank", []string{"searchByStatus", "ACTIVE"}},
+		{n.vantiv, "vantiv", []string{"readAllReferrals"}},
+	} {
+		if result := string(n.query(query.caller, query.chaincodeName, query.args...)); strings.Contains(result, "Ann Lee") || !strings.Contains(result, `"ciphertext"`) {
+			t.Fatalf("expected %s on %s to return the PII sealed, got %s", query.args[0], query.chaincodeName, result)
+		}
+	}
+
+	var entries []partnerlogic.AuditEntry
+	err := json.Unmarshal(n.query(n.manager, "bank", "readAuditLog"), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Action != partnerlogic.AuditPIIRead {
+		t.Fatalf("expected the audited read alone in the log, got %+v", entries)
+	}
+
+	n.ledger.SetCaller(n.manager)
+	n.expect(n.ledger.Query("bank", "read", "AUDIT_"), 400)
+}
+
+
+func TestCustomerHashesNeedTheGatewaySalt(t *testing.T) {
+	n := newNetwork(t)
+	referral := `{"referralId":"r1","customerId":"c1","departments":["Vantiv"],"consent":{"scope":["Vantiv"],"channel":"BRANCH","timestamp":1,"capturedBy":"e1"}}`
+
+	for _, test := range []struct {
+		salt string
+		status int32
+	}{
+		{"", 400},
+		{"salt", 403},
+	} {
+		n.ledger.SetCaller(n.employee)
+		n.ledger.SetTransient(map[string][]byte{"piiKey.Bank": bankPIIKey["piiKey.Bank"], bank.DuplicateSaltTransientName: []byte(test.salt), partnerlogic.PIITransientName: annLee[partnerlogic.PIITransientName]})
+		n.expect(n.ledger.Invoke("bank", "createReferral", "r1", referral), test.status)
+	}
+
+	n.createReferral("r1", "c1")
+	n.createReferral("r2", "c2")
+
+	var referrals []partnerlogic.CustomerReferral
+	err := json.Unmarshal(n.query(n.manager, "bank", "searchByStatus", "ACTIVE"), &referrals)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(referrals) != 2 || referrals[1].DuplicateOf != "r1" {
+		t.Fatalf("expected r2 flagged as a duplicate of r1, got %+v", referrals)
+	}
+
+	if config := string(n.query(n.manager, "bank", "getConfig")); strings.Contains(config, "pepper") || strings.Contains(string(n.ledger.GetState("bank", "CONFIG")), "pepper") {
+		t.Fatalf("expected the salt kept off the ledger, got %s", config)
+	}
+}
+
+
+func TestLeaderboardCountsNoDealsEmployeesCloseThemselves(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+	n.createReferral("r2", "c1")
+
+	// r2 is a duplicate of r1, so it was not forwarded and its status is the bank's own
+	n.invoke(n.employee, "bank", "updateReferralStatus", "r2", "CLOSED")
+
+	var board bank.Leaderboard
+	err := json.Unmarshal(n.query(n.manager, "bank", "leaderboard", "2026-01-01", "2026-01-31"), &board)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range board.ByDealsClosed {
+		if entry.Closed != 0 {
+			t.Fatalf("expected no deals closed, got %+v", entry)
+		}
+	}
+}
+
+
+func TestStatsCountReferralsStillOpenPastTheExpiryAge(t *testing.T) {
+	n := newNetwork(t)
+	n.createCustomerReferral("r1", "c1", "+1 555 123 4567")
+	n.createCustomerReferral("r2", "c2", "+1 555 765 4321")
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r2", "MID")
+	n.invoke(n.manager, "bank", "syncPartnerStatus", "Vantiv", "r2")
+
+	for _, test := range []struct {
+		age time.Duration
+		expired int64
+	}{
+		{89 * 24 * time.Hour, 0},
+		{2 * 24 * time.Hour, 1},
+	} {
+		n.ledger.Advance(test.age)
+
+		var report partnerlogic.StatsReport
+		err := json.Unmarshal(n.query(n.manager, "bank", "referralStats", partnerlogic.DimensionAll), &report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(report.Groups) != 1 || report.Groups[0].Counts[partnerlogic.StatExpired] != test.expired || report.Groups[0].ExpiryRate != float64(test.expired) / 2 {
+			t.Fatalf("expected %d of 2 referrals expired %s later, got %+v", test.expired, test.age, report)
+		}
+	}
+}
+
+
+func TestStageDurationsMeasureEachReferralUpToTheDayItClosed(t *testing.T) {
+	n := newNetwork(t)
+	n.createCustomerReferral("r1", "c1", "+1 555 123 4567")
+	n.createCustomerReferral("r2", "c2", "+1 555 765 4321")
+
+	// r1 closes two hours after it was made and r2 a day later
+	n.ledger.Advance(2 * time.Hour)
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "MID")
+	n.ledger.Advance(24 * time.Hour)
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r2", "MID")
+
+	for _, test := range []struct {
+		args []string
+		count int
+		median, max int64
+	}{
+		{[]string{"partner"}, 2, 7200, 93600},
+		{[]string{"partner", "2026-01-01", "2026-01-01"}, 1, 7200, 7200},
+		{[]string{"partner", "2026-01-02"}, 1, 93600, 93600},
+	} {
+		var report partnerlogic.DurationReport
+		err := json.Unmarshal(n.query(n.vantiv, "vantiv", append([]string{"stageDurations"}, test.args...)...), &report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var closing *partnerlogic.StageDuration
+		for _, group := range report.Groups {
+			for i, stage := range group.Stages {
+				if group.Value == "Vantiv" && stage.Stage == partnerlogic.TimeToClose {
+					closing = &group.Stages[i]
+				}
+			}
+		}
+
+		if closing == nil || closing.Count != test.count || closing.Median != test.median || closing.Max != test.max {
+			t.Errorf("%v: expected %d closings with median %d and max %d, got %+v", test.args, test.count, test.median, test.max, report)
+		}
+	}
+}
+
+
+func TestUnknownFunctionsAreInvalidArguments(t *testing.T) {
+	n := newNetwork(t)
+
+	for _, chaincodeName := range []string{"bank", "vantiv"} {
+		response := n.submit(n.manager, nil, chaincodeName, "closeEveryDeal")
+		n.expect(response, 400)
+
+		var chaincodeError partnerlogic.ChaincodeError
+		err := json.Unmarshal([]byte(response.Message), &chaincodeError)
+		if err != nil || chaincodeError.Field != "function" {
+			t.Fatalf("expected %s to report the function as the invalid argument, got %s", chaincodeName, response.Message)
+		}
+	}
+}
+
+
+func TestStatementsAreConfirmedOnceByEachSide(t *testing.T) {
+	n := newNetwork(t)
+	n.createReferral("r1", "c1")
+	n.invoke(n.vantiv, "vantiv", "closeReferredDeal", "r1", "MID")
+
+	vantiv, err := simulator.NewIdentity("VantivMSP", "vantivAdmin", map[string]string{partnerlogic.AttrRole: partnerlogic.RolePartnerAdmin, partnerlogic.AttrPartner: "Vantiv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	managerInVantivMSP, err := simulator.NewIdentity("VantivMSP", "vantivManager", map[string]string{partnerlogic.AttrRole: partnerlogic.RoleBankManager})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.ledger.SetTime(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, test := range []struct {
+		caller *simulator.Identity
+		status int32
+		confirmed bool
+	}{
+		{vantiv, shim.OK, false},
+		{vantiv, 409, false},
+		{managerInVantivMSP, 409, false},
+		{n.manager, shim.OK, true},
+		{n.identity("bank.manager", "", "m2"), 409, true},
+	} {
+		response := n.submit(test.caller, nil, "vantiv", "recordStatement", "2026-01")
+		n.expect(response, test.status)
+
+		var statement partnerlogic.Statement
+		err = json.Unmarshal(n.query(n.manager, "vantiv", "readStatement", "2026-01"), &statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if statement.Record == nil || statement.Record.Confirmed != test.confirmed {
+			t.Fatalf("expected the statement confirmed by both sides to be %t, got %+v", test.confirmed, statement.Record)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package simulator
+
+import (
+	"errors"
+	"sort"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Stub is the ChaincodeStubInterface handed to a chaincode running on a Ledger. It implements the
+// arguments, transaction id, timestamp, creator, transient data and signed proposal, world state, range
+// queries, events and chaincode to chaincode calls. The other methods of the interface are not simulated
+// and panic if called. called is set on the stubs of chaincodes reached through InvokeChaincode
+type Stub struct {
+	shim.ChaincodeStubInterface
+	tx *transaction
+	chaincodeName string
+	args [][]byte
+	called bool
+}
+
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *Stub) GetStringArgs() []string {
+	var args []string
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+
+	return args
+}
+
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+
+	return args[0], args[1:]
+}
+
+func (s *Stub) GetArgsSlice() ([]byte, error) {
+	var slice []byte
+	for _, arg := range s.args {
+		slice = append(slice, arg...)
+	}
+
+	return slice, nil
+}
+
+func (s *Stub) GetTxID() string {
+	return s.tx.txId
+}
+
+func (s *Stub) GetChannelID() string {
+	return s.tx.ledger.ChannelId
+}
+
+func (s *Stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.tx.timestamp.Unix(), Nanos: int32(s.tx.timestamp.Nanosecond())}, nil
+}
+
+// GetCreator returns the identity of the caller set on the ledger. Chaincodes called from another
+// chaincode see the same creator
+func (s *Stub) GetCreator() ([]byte, error) {
+	if s.tx.creator == nil {
+		return nil, errors.New("the transaction has no creator")
+	}
+
+	return s.tx.creator, nil
+}
+
+func (s *Stub) GetTransient() (map[string][]byte, error) {
+	return s.tx.transient, nil
+}
+
+// GetSignedProposal returns the proposal the transaction was submitted with. Chaincodes called from
+// another chaincode see the same proposal, naming the chaincode the transaction was submitted to. The
+// proposal is not signed
+func (s *Stub) GetSignedProposal() (*pb.SignedProposal, error) {
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{
+		Type: int32(common.HeaderType_ENDORSER_TRANSACTION),
+		ChannelId: s.tx.ledger.ChannelId,
+		TxId: s.tx.txId,
+		Timestamp: &timestamp.Timestamp{Seconds: s.tx.timestamp.Unix(), Nanos: int32(s.tx.timestamp.Nanosecond())},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signatureHeader, err := proto.Marshal(&common.SignatureHeader{Creator: s.tx.creator})
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader, SignatureHeader: signatureHeader})
+	if err != nil {
+		return nil, err
+	}
+
+	invocation, err := proto.Marshal(&pb.ChaincodeInvocationSpec{
+		ChaincodeSpec: &pb.ChaincodeSpec{
+			Type: pb.ChaincodeSpec_GOLANG,
+			ChaincodeId: &pb.ChaincodeID{Name: s.tx.chaincodeName},
+			Input: &pb.ChaincodeInput{Args: s.tx.args},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := proto.Marshal(&pb.ChaincodeProposalPayload{Input: invocation})
+	if err != nil {
+		return nil, err
+	}
+
+	proposal, err := proto.Marshal(&pb.Proposal{Header: header, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SignedProposal{ProposalBytes: proposal}, nil
+}
+
+// InvokeChaincode runs a function of another chaincode on the ledger as part of the same transaction
+func (s *Stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	if channel != "" && channel != s.tx.ledger.ChannelId {
+		return shim.Error("Channel " + channel + " is not simulated")
+	}
+
+	return s.tx.call(chaincodeName, false, args, true)
+}
+
+// GetState returns the committed value of a key. Writes made earlier in the same transaction are not
+// visible, as on a peer
+func (s *Stub) GetState(key string) ([]byte, error) {
+	return s.tx.ledger.state[s.chaincodeName][key], nil
+}
+
+func (s *Stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+
+	s.tx.put(s.chaincodeName, key, write{value: value})
+	return nil
+}
+
+func (s *Stub) DelState(key string) error {
+	s.tx.put(s.chaincodeName, key, write{deleted: true})
+	return nil
+}
+
+// GetStateByRange iterates over the committed keys from startKey up to but not including endKey.
+// An empty startKey or endKey leaves that end of the range open
+func (s *Stub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	state := s.tx.ledger.state[s.chaincodeName]
+
+	var keys []string
+	for key := range state {
+		if (startKey == "" || key >= startKey) && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var results []*queryresult.KV
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Namespace: s.chaincodeName, Key: key, Value: state[key]})
+	}
+
+	return &stateIterator{results: results}, nil
+}
+
+// SetEvent records the event published when the transaction commits. As on a peer, a transaction has
+// one event: each call replaces the event set before it, and events set by chaincodes reached through
+// InvokeChaincode are dropped
+func (s *Stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+
+	if s.called {
+		return nil
+	}
+
+	s.tx.event = &Event{ChaincodeName: s.chaincodeName, TxId: s.tx.txId, EventName: name, Payload: payload}
+	return nil
+}
+
+// stateIterator walks the results of a range query
+type stateIterator struct {
+	results []*queryresult.KV
+	next int
+}
+
+func (it *stateIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, errors.New("no more results")
+	}
+
+	result := it.results[it.next]
+	it.next++
+
+	return result, nil
+}
+
+func (it *stateIterator) Close() error {
+	return nil
+}